@@ -1,18 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"sort"
 	"strings"
+	"sync"
 
 	"github.com/nfnt/resize"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -26,11 +27,44 @@ import (
 
 // App struct
 type App struct {
+	// mu guards items, tagFrequency and issues; bound methods and the
+	// folder watcher run on different goroutines
+	mu sync.Mutex
+	// scanMu guards scanCancel and scanGen, so a scan can be cancelled while it
+	// runs; scanGen numbers the scans so a superseded one leaves the newer alone
+	scanMu     sync.Mutex
+	scanCancel context.CancelFunc
+	scanGen    uint64
+
 	ctx          context.Context
 	datasetPath  string
 	items        []DatasetItem
 	tagFrequency map[string]int
 	thumbnailDir string
+	issues       []ScanIssue
+	sortOrder    SortOrder
+	watcher      *datasetWatcher
+	config       DatasetConfig
+	// datasetRules and globalRules hold the tag aliases and implications
+	datasetRules TagRules
+	globalRules  TagRules
+	// dictionary assigns categories to tags; nil when none is configured
+	dictionary *tagDictionary
+	// phrases and words index the captions for analyzeCommonPhrases, by runes
+	// and by words. segmenter splits Chinese captions into words. phraseConfig
+	// is config.Phrases or the unsaved options of the last RefreshTagStats, and
+	// phraseFilter holds its stopwords and blocklist.
+	phrases      *phraseIndex
+	words        *wordIndex
+	segmenter    *segmenter
+	phraseConfig PhraseConfig
+	phraseFilter phraseFilter
+	pairing      *pairer
+	// source reads the loaded dataset: the filesystem or an archive
+	source datasetSource
+	// archiveSave and archiveChanges control saving into an archived dataset
+	archiveSave    string
+	archiveChanges map[string][]byte
 }
 
 // DatasetItem represents a single image/video with its tags
@@ -40,9 +74,20 @@ type DatasetItem struct {
 	TxtPath       string   `json:"txtPath"`
 	Tags          []string `json:"tags"`
 	RawTags       string   `json:"rawTags"`
+	Sidecars      []string `json:"sidecars,omitempty"`
 	ThumbnailPath string   `json:"thumbnailPath"`
 	ThumbnailData string   `json:"thumbnailData"`
 	IsVideo       bool     `json:"isVideo"`
+	Concept       string   `json:"concept,omitempty"`
+	ConceptDir    string   `json:"conceptDir,omitempty"`
+	Repeats       int      `json:"repeats,omitempty"`
+	Width         int      `json:"width"`
+	Height        int      `json:"height"`
+	Aspect        float64  `json:"aspect"`
+	Megapixels    float64  `json:"megapixels"`
+	Bytes         int64    `json:"bytes"`
+	ModTime       int64    `json:"mtime"` // unix milliseconds
+	Format        string   `json:"format"`
 	Selected      bool     `json:"selected"`
 	Modified      bool     `json:"modified"`
 }
@@ -51,24 +96,42 @@ type DatasetItem struct {
 type TagInfo struct {
 	Tag   string `json:"tag"`
 	Count int    `json:"count"`
+	// Category comes from the tag dictionary, empty when none is loaded
+	Category string `json:"category,omitempty"`
 }
 
 // ScanResult represents the result of scanning a folder
 type ScanResult struct {
-	Success     bool          `json:"success"`
-	Message     string        `json:"message"`
-	Items       []DatasetItem `json:"items"`
-	Tags        []TagInfo     `json:"tags"`
-	TotalItems  int           `json:"totalItems"`
-	TotalImages int           `json:"totalImages"`
-	TotalVideos int           `json:"totalVideos"`
-}
+	Success     bool           `json:"success"`
+	Message     string         `json:"message"`
+	Items       []DatasetItem  `json:"items"`
+	Tags        []TagInfo      `json:"tags"`
+	Categories  []CategoryStat `json:"categories"`
+	TotalItems  int            `json:"totalItems"`
+	TotalImages int            `json:"totalImages"`
+	TotalVideos int            `json:"totalVideos"`
+	Issues      []ScanIssue    `json:"issues"`
+	Concepts    []ConceptInfo  `json:"concepts"`
+	Archive     bool           `json:"archive"`
+}
+
+// Supported media extensions
+var (
+	imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
+	videoExts = map[string]bool{".mp4": true, ".avi": true, ".mov": true, ".mkv": true, ".webm": true, ".flv": true}
+)
 
 // NewApp creates a new App application struct
 func NewApp() *App {
 	return &App{
 		items:        make([]DatasetItem, 0),
 		tagFrequency: make(map[string]int),
+		sortOrder:    defaultSortOrder,
+		config:       defaultDatasetConfig(),
+		phraseConfig: defaultPhraseConfig(),
+		pairing:      newPairer("", defaultPairingConfig()),
+		source:       osSource{},
+		archiveSave:  ArchiveSaveExtract,
 	}
 }
 
@@ -91,152 +154,61 @@ func (a *App) SelectFolder() (string, error) {
 	return dir, nil
 }
 
-// ScanFolder scans the selected folder for image/video + txt pairs
+// ScanFolder scans the selected folder for image/video + caption pairs.
+// Progress is pushed as scan:progress events; CancelScan aborts it.
 func (a *App) ScanFolder(folderPath string) ScanResult {
-	a.datasetPath = folderPath
-	a.items = make([]DatasetItem, 0)
-	a.tagFrequency = make(map[string]int)
-
-	imageExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
-	videoExts := map[string]bool{".mp4": true, ".avi": true, ".mov": true, ".mkv": true, ".webm": true, ".flv": true}
-
-	mediaFiles := make(map[string]string)
-	txtFiles := make(map[string]string)
-
-	totalImages := 0
-	totalVideos := 0
-
-	// Walk through directory
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		ext := strings.ToLower(filepath.Ext(path))
-		baseName := strings.TrimSuffix(filepath.Base(path), ext)
-		dir := filepath.Dir(path)
-		key := filepath.Join(dir, baseName)
-
-		if ext == ".txt" {
-			txtFiles[key] = path
-		} else if imageExts[ext] {
-			mediaFiles[key] = path
-		} else if videoExts[ext] {
-			mediaFiles[key] = path
-		}
+	defer a.endScan(a.beginScan(cancel))
 
-		return nil
+	return a.scanFolder(ctx, folderPath, func(p ScanProgress) {
+		a.emit(EventScanProgress, p)
 	})
+}
 
-	if err != nil {
-		return ScanResult{Success: false, Message: fmt.Sprintf("扫描失败: %v", err)}
-	}
-
-	// Match media files with txt files
-	for key, mediaPath := range mediaFiles {
-		txtPath, hasTxt := txtFiles[key]
-
-		ext := strings.ToLower(filepath.Ext(mediaPath))
-		isVideo := videoExts[ext]
-
-		if isVideo {
-			totalVideos++
-		} else {
-			totalImages++
-		}
-
-		item := DatasetItem{
-			ID:        key,
-			MediaPath: mediaPath,
-			TxtPath:   txtPath,
-			IsVideo:   isVideo,
-			Tags:      []string{},
-			RawTags:   "",
-		}
+// beginScan cancels the scan still running, if any, and makes cancel the one
+// CancelScan calls; it returns the generation of the new scan
+func (a *App) beginScan(cancel context.CancelFunc) uint64 {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
 
-		// Read tags from txt file
-		if hasTxt {
-			content, err := os.ReadFile(txtPath)
-			if err == nil {
-				item.RawTags = string(content)
-				tags := a.parseTags(string(content))
-				item.Tags = tags
-
-				// Update frequency
-				for _, tag := range tags {
-					a.tagFrequency[tag]++
-				}
-			}
-		}
-
-		a.items = append(a.items, item)
+	if a.scanCancel != nil {
+		// 新的扫描取代仍在进行的扫描
+		a.scanCancel()
 	}
+	a.scanGen++
+	a.scanCancel = cancel
+	return a.scanGen
+}
 
-	// 分析共同短语（子串频率统计）
-	tagInfos := a.analyzeCommonPhrases()
+// endScan forgets the cancel func of scan gen, unless a newer scan replaced it
+func (a *App) endScan(gen uint64) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
 
-	return ScanResult{
-		Success:     true,
-		Message:     fmt.Sprintf("成功扫描 %d 个文件", len(a.items)),
-		Items:       a.items,
-		Tags:        tagInfos,
-		TotalItems:  len(a.items),
-		TotalImages: totalImages,
-		TotalVideos: totalVideos,
+	if a.scanGen == gen {
+		a.scanCancel = nil
 	}
 }
 
-// parseTags splits tag string into individual tags (for display/edit)
-func (a *App) parseTags(content string) []string {
-	// Common separators: comma, newline
-	content = strings.ReplaceAll(content, "\r\n", ",")
-	content = strings.ReplaceAll(content, "\n", ",")
+// CancelScan aborts a running ScanFolder; the previously loaded dataset is kept
+func (a *App) CancelScan() {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
 
-	parts := strings.Split(content, ",")
-	tags := make([]string, 0)
-
-	for _, p := range parts {
-		tag := strings.TrimSpace(p)
-		if tag != "" {
-			tags = append(tags, tag)
-		}
+	if a.scanCancel != nil {
+		a.scanCancel()
 	}
-
-	return tags
 }
 
-// extractSubstrings 提取文本中所有长度在minLen到maxLen之间的纯净子串（不含标点）
-func (a *App) extractSubstrings(content string, minLen, maxLen int) map[string]bool {
-	substrings := make(map[string]bool)
-
-	// 先按标点符号分割文本
-	segments := a.splitByPunctuation(content)
-
-	for _, segment := range segments {
-		segment = strings.TrimSpace(segment)
-		if len(segment) == 0 {
-			continue
-		}
-
-		runes := []rune(segment)
-		length := len(runes)
-
-		for i := 0; i < length; i++ {
-			for l := minLen; l <= maxLen && i+l <= length; l++ {
-				sub := string(runes[i : i+l])
-				sub = strings.TrimSpace(sub)
-				// 确保子串不为空且不含任何标点符号
-				if len(sub) > 0 && !a.containsPunctuation(sub) {
-					substrings[sub] = true
-				}
-			}
-		}
-	}
-	return substrings
+// parseTags splits tag string into individual tags (for display/edit)
+func (a *App) parseTags(content string) []string {
+	return captionTags(content, a.config.Caption)
 }
 
 // splitByPunctuation 按标点符号分割文本
-func (a *App) splitByPunctuation(s string) []string {
+func splitByPunctuation(s string) []string {
 	punctuation := `,.!?;:，。！？；：、""''「」【】（）()[]{}《》<>-_—·…` + "\n\r\t "
 	result := make([]string, 0)
 	current := strings.Builder{}
@@ -259,17 +231,6 @@ func (a *App) splitByPunctuation(s string) []string {
 	return result
 }
 
-// containsPunctuation 检查字符串是否包含任何标点符号
-func (a *App) containsPunctuation(s string) bool {
-	punctuation := `,.!?;:，。！？；：、""''「」【】（）()[]{}《》<>-_—·…` + "\n\r\t "
-	for _, r := range s {
-		if strings.ContainsRune(punctuation, r) {
-			return true
-		}
-	}
-	return false
-}
-
 // isPunctuation 检查字符串是否只包含标点符号
 func (a *App) isPunctuation(s string) bool {
 	punctuation := `,.!?;:，。！？；：、""''「」【】（）()[]{}《》<>-_—·… ` + "\n\r\t"
@@ -281,115 +242,45 @@ func (a *App) isPunctuation(s string) bool {
 	return true
 }
 
-// analyzeCommonPhrases 分析所有文件中的共同短语
+// analyzeCommonPhrases 按数据集的短语设置分析所有文件中的共同短语：不含标点的子串，
+// 或词组成的短语（中文按词典分词，英文按空格）；被出现在相同标注中的更长短语包含的
+// 短语不再单独列出。索引在两次分析之间只重新统计变化过的标注。
 func (a *App) analyzeCommonPhrases() []TagInfo {
-	// 存储每个子串出现在哪些文件中
-	phraseFileCount := make(map[string]int)
-	// 存储每个子串的总出现次数
-	phraseFrequency := make(map[string]int)
-
-	// 遍历所有文件，提取子串
-	for _, item := range a.items {
-		if item.RawTags == "" {
-			continue
-		}
-		// 提取这个文件中的所有子串（长度2-15个字符）
-		substrings := a.extractSubstrings(item.RawTags, 2, 15)
-
-		// 统计每个子串出现在多少个文件中
-		for sub := range substrings {
-			phraseFileCount[sub]++
-			phraseFrequency[sub]++
-		}
+	cfg := a.phraseConfig
+	if a.phrases == nil || a.phrases.maxLen != int32(cfg.MaxChars) {
+		a.phrases = newPhraseIndex(cfg.MaxChars)
 	}
-
-	// 只保留出现在2个或以上文件中的短语（真正的共同短语）
-	commonPhrases := make([]TagInfo, 0)
-	for phrase, fileCount := range phraseFileCount {
-		if fileCount >= 2 {
-			commonPhrases = append(commonPhrases, TagInfo{
-				Tag:   phrase,
-				Count: fileCount,
-			})
-		}
+	if a.words == nil || a.words.segmenter != a.segmenter || a.words.maxLen != cfg.MaxWords {
+		// 分词词典或最大词数变了，所有标注都要重新统计
+		a.words = newWordIndex(a.segmenter, cfg.MaxWords)
 	}
 
-	// 按出现文件数排序，相同则按长度排序（优先显示更长的短语）
-	sort.Slice(commonPhrases, func(i, j int) bool {
-		if commonPhrases[i].Count != commonPhrases[j].Count {
-			return commonPhrases[i].Count > commonPhrases[j].Count
+	// 按模式把标注分给字索引和词索引，自动模式逐条判断文字
+	chars := make([]DatasetItem, 0, len(a.items))
+	words := make([]DatasetItem, 0, len(a.items))
+	for _, item := range a.items {
+		if countsByRunes(cfg.Mode, item.RawTags) {
+			chars = append(chars, item)
+		} else {
+			words = append(words, item)
 		}
-		// 优先显示更长的短语（更有意义）
-		return len([]rune(commonPhrases[i].Tag)) > len([]rune(commonPhrases[j].Tag))
-	})
-
-	// 过滤掉被更长短语包含的短子串
-	filteredPhrases := a.filterSubPhrases(commonPhrases)
-
-	// 限制返回前100个
-	if len(filteredPhrases) > 100 {
-		filteredPhrases = filteredPhrases[:100]
-	}
-
-	return filteredPhrases
-}
-
-// filterSubPhrases 过滤掉被更长短语包含的短子串
-// 核心原则：最长匹配优先，只有当短子串完全被更长短语覆盖时才过滤
-// 例如："正面视角"和"侧面视角"不应该被合并成"面视角"
-func (a *App) filterSubPhrases(phrases []TagInfo) []TagInfo {
-	if len(phrases) == 0 {
-		return phrases
 	}
-
-	// 先按长度从长到短排序，同长度按count排序
-	sort.Slice(phrases, func(i, j int) bool {
-		lenI := len([]rune(phrases[i].Tag))
-		lenJ := len([]rune(phrases[j].Tag))
-		if lenI != lenJ {
-			return lenI > lenJ // 长的优先
-		}
-		return phrases[i].Count > phrases[j].Count
-	})
-
-	result := make([]TagInfo, 0)
-	removed := make(map[string]bool)
-
-	// 从最长的开始，标记被包含的短子串
-	for _, phrase := range phrases {
-		if removed[phrase.Tag] {
-			continue
-		}
-
-		result = append(result, phrase)
-
-		// 标记所有被当前短语完全包含且count相同的更短子串
-		for _, other := range phrases {
-			if other.Tag == phrase.Tag {
-				continue
-			}
-			// 只有当短子串被完全包含，且count完全相同时才过滤
-			// 这样"正面视角"(count=5)和"侧面视角"(count=3)都会保留
-			// 因为它们的count不同，即使都包含"面视角"
-			if strings.Contains(phrase.Tag, other.Tag) && phrase.Count == other.Count {
-				removed[other.Tag] = true
-			}
-		}
-
-		if len(result) >= 100 {
-			break
+	a.phrases = a.phrases.sync(chars)
+	a.words.sync(words)
+
+	// 出现在过多标注中的短语（如每条都有的触发词）不列出
+	maxDocs := int32(math.Floor(cfg.MaxDocRatio * float64(len(a.items))))
+	// 按出现文件数排序，相同则优先显示更长的短语，限制返回数量
+	phrases := a.phrases.common(cfg, maxDocs, a.phraseFilter)
+	if len(words) > 0 {
+		phrases = append(phrases, a.words.common(cfg, maxDocs, a.phraseFilter)...)
+		sortPhrases(phrases)
+		if len(phrases) > cfg.Limit {
+			phrases = phrases[:cfg.Limit]
 		}
 	}
-
-	// 按count重新排序
-	sort.Slice(result, func(i, j int) bool {
-		if result[i].Count != result[j].Count {
-			return result[i].Count > result[j].Count
-		}
-		return len([]rune(result[i].Tag)) > len([]rune(result[j].Tag))
-	})
-
-	return result
+	a.categorizeTags(phrases)
+	return phrases
 }
 
 // GetThumbnail generates and returns thumbnail as base64
@@ -422,26 +313,23 @@ func (a *App) GetThumbnail(mediaPath string, isVideo bool) string {
 
 // generateImageThumbnail creates a thumbnail for an image
 func (a *App) generateImageThumbnail(imagePath, cachePath string) []byte {
-	// 检查文件是否存在
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+	// 压缩包中的条目无法 Seek，整体读入内存后解码
+	data, err := a.currentSource().ReadFile(imagePath)
+	if os.IsNotExist(err) {
 		fmt.Printf("图片文件不存在: %s\n", imagePath)
 		return nil
 	}
-
-	file, err := os.Open(imagePath)
 	if err != nil {
 		fmt.Printf("打开图片失败 [%s]: %v\n", imagePath, err)
 		return nil
 	}
-	defer file.Close()
 
 	// 尝试解码图片
-	img, format, err := image.Decode(file)
+	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		fmt.Printf("解码图片失败 [%s] (格式: %s): %v\n", imagePath, format, err)
-		// 尝试重新打开并使用JPEG解码
-		file.Seek(0, 0)
-		img, err = jpeg.Decode(file)
+		// 尝试重新使用JPEG解码
+		img, err = jpeg.Decode(bytes.NewReader(data))
 		if err != nil {
 			fmt.Printf("JPEG解码也失败 [%s]: %v\n", imagePath, err)
 			return nil
@@ -465,7 +353,7 @@ func (a *App) generateImageThumbnail(imagePath, cachePath string) []byte {
 	}
 
 	// Read back
-	data, err := os.ReadFile(cachePath)
+	data, err = os.ReadFile(cachePath)
 	if err != nil {
 		fmt.Printf("读取缓存失败 [%s]: %v\n", cachePath, err)
 		return nil
@@ -475,6 +363,19 @@ func (a *App) generateImageThumbnail(imagePath, cachePath string) []byte {
 
 // generateVideoThumbnail extracts middle frame from video using ffmpeg
 func (a *App) generateVideoThumbnail(videoPath, cachePath string) []byte {
+	// ffmpeg 只能读取真实文件，压缩包中的视频先解压到临时文件
+	if archive, ok := a.currentSource().(*archiveSource); ok {
+		if _, inside := archive.entryName(videoPath); inside {
+			tmpPath, err := extractTempFile(archive, videoPath)
+			if err != nil {
+				fmt.Printf("解压视频失败 [%s]: %v\n", videoPath, err)
+				return nil
+			}
+			defer os.Remove(tmpPath)
+			videoPath = tmpPath
+		}
+	}
+
 	// First get video duration
 	durationCmd := exec.Command("ffprobe",
 		"-v", "error",
@@ -516,36 +417,77 @@ func (a *App) extractFrameAt(videoPath, outputPath, timePos string) error {
 	return cmd.Run()
 }
 
-// RefreshTagStats 刷新标签统计（重新分析共同短语）
-func (a *App) RefreshTagStats() map[string]interface{} {
+// RefreshTagStats 刷新标签统计（重新分析共同短语）。opts 只在本次会话中生效，
+// 不写入数据集配置，需要保存时调用 SavePhraseConfig；零值字段取默认值。
+func (a *App) RefreshTagStats(opts PhraseConfig) map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	opts.normalize()
+	err := opts.validate()
+	if err == nil && opts != a.phraseConfig {
+		err = a.usePhraseConfig(opts)
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		}
+	}
+
 	// 重新分析共同短语
 	tagInfos := a.analyzeCommonPhrases()
 
 	return map[string]interface{}{
-		"success": true,
-		"tags":    tagInfos,
-		"message": fmt.Sprintf("统计完成，共 %d 个共同短语", len(tagInfos)),
+		"success":    true,
+		"tags":       tagInfos,
+		"categories": a.categoryStats(),
+		"message":    fmt.Sprintf("统计完成，共 %d 个共同短语", len(tagInfos)),
 	}
 }
 
+// setItemCaption replaces the caption of the item at idx and keeps the exact-tag
+// counts in step; the caller must hold a.mu
+func (a *App) setItemCaption(idx int, raw string) {
+	a.adjustTagFrequency(a.items[idx].Tags, -1)
+	a.items[idx].RawTags = raw
+	a.items[idx].Tags = a.parseTags(raw)
+	a.adjustTagFrequency(a.items[idx].Tags, 1)
+}
+
 // SaveTags saves tags for a specific item
 func (a *App) SaveTags(itemID string, tags string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rebase, err := a.prepareWrite(false)
+	if err != nil {
+		return err
+	}
+	if err := a.saveTags(rebase(itemID), tags); err != nil {
+		return err
+	}
+	return a.flushArchive()
+}
+
+// saveTags writes the caption file; the caller must hold a.mu
+func (a *App) saveTags(itemID string, tags string) error {
 	for i, item := range a.items {
 		if item.ID == itemID {
 			txtPath := item.TxtPath
 			if txtPath == "" {
-				// Create new txt file
-				txtPath = strings.TrimSuffix(item.MediaPath, filepath.Ext(item.MediaPath)) + ".txt"
-				a.items[i].TxtPath = txtPath
+				// Create new caption file where the pairing rules expect it
+				txtPath = a.pairing.captionPath(item.MediaPath)
 			}
 
-			err := os.WriteFile(txtPath, []byte(tags), 0644)
-			if err != nil {
+			if err := a.writeDatasetFile(txtPath, []byte(tags)); err != nil {
 				return err
 			}
+			a.items[i].TxtPath = txtPath
 
-			a.items[i].RawTags = tags
-			a.items[i].Tags = a.parseTags(tags)
+			a.setItemCaption(i, tags)
+			// 已写入文件，之后的外部修改可以直接应用
+			a.items[i].Modified = false
 			return nil
 		}
 	}
@@ -554,38 +496,47 @@ func (a *App) SaveTags(itemID string, tags string) error {
 
 // SaveAllChanges saves all modified items
 func (a *App) SaveAllChanges(items []DatasetItem) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rebase, err := a.prepareWrite(false)
+	if err != nil {
+		return err
+	}
 	for _, item := range items {
 		if item.Modified {
-			err := a.SaveTags(item.ID, item.RawTags)
+			err := a.saveTags(rebase(item.ID), item.RawTags)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	return nil
+	// 压缩包在全部修改写入后只重写一次
+	return a.flushArchive()
 }
 
-// BatchAddTag adds a tag to multiple items
+// BatchAddTag adds a tag to multiple items; prepending keeps the item's keep
+// tokens in front
 func (a *App) BatchAddTag(itemIDs []string, tag string, position string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.batchAddTag(itemIDs, tag, position)
+}
+
+// batchAddTag is BatchAddTag for callers that already hold a.mu
+func (a *App) batchAddTag(itemIDs []string, tag string, position string) error {
 	for _, id := range itemIDs {
 		for i, item := range a.items {
 			if item.ID == id {
 				var newTags string
-				if position == "prepend" {
-					if item.RawTags == "" {
-						newTags = tag
-					} else {
-						newTags = tag + ", " + item.RawTags
-					}
+				if keep := a.keepTokens(item); position == "prepend" && len(keep) > 0 {
+					// 触发词保持在最前面，新标签插到它们之后
+					newTags = insertCaptionTag(item.RawTags, a.config.Caption, tag, keepInsertIndex(item.Tags, keep, bareTag(tag)))
 				} else {
-					if item.RawTags == "" {
-						newTags = tag
-					} else {
-						newTags = item.RawTags + ", " + tag
-					}
+					newTags = addCaptionTag(item.RawTags, a.config.Caption, tag, position)
 				}
-				a.items[i].RawTags = newTags
-				a.items[i].Tags = a.parseTags(newTags)
+				a.setItemCaption(i, newTags)
 				a.items[i].Modified = true
 			}
 		}
@@ -593,26 +544,23 @@ func (a *App) BatchAddTag(itemIDs []string, tag string, position string) error {
 	return nil
 }
 
-// BatchRemoveTag removes a tag from multiple items
+// BatchRemoveTag removes a tag from multiple items, except from the protected
+// keep tokens at the start of a caption
 func (a *App) BatchRemoveTag(itemIDs []string, tag string, useRegex bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, err := newPhraseMatcher(tag, useRegex)
+	if err != nil {
+		return err
+	}
 	for _, id := range itemIDs {
 		for i, item := range a.items {
 			if item.ID == id {
-				var newTags []string
-				for _, t := range item.Tags {
-					if useRegex {
-						matched, _ := regexp.MatchString(tag, t)
-						if !matched {
-							newTags = append(newTags, t)
-						}
-					} else {
-						if t != tag {
-							newTags = append(newTags, t)
-						}
-					}
-				}
-				a.items[i].RawTags = strings.Join(newTags, ", ")
-				a.items[i].Tags = newTags
+				// 开头的触发词不会被删除
+				protected := protectedTagCount(item.Tags, a.keepTokens(item))
+				newTags := removeCaptionTag(item.RawTags, a.config.Caption, m, protected)
+				a.setItemCaption(i, newTags)
 				a.items[i].Modified = true
 			}
 		}
@@ -620,30 +568,29 @@ func (a *App) BatchRemoveTag(itemIDs []string, tag string, useRegex bool) error
 	return nil
 }
 
-// BatchReplaceTag replaces a tag in multiple items
-func (a *App) BatchReplaceTag(itemIDs []string, oldTag string, newTag string, useRegex bool) error {
+// BatchReplaceTag replaces a tag in multiple items; weightMode (keep, explicit or
+// strip, default keep) decides what happens to the emphasis of replaced tags
+func (a *App) BatchReplaceTag(itemIDs []string, oldTag string, newTag string, useRegex bool, weightMode string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if weightMode == "" {
+		weightMode = WeightKeep
+	}
+	if err := validateWeightMode(weightMode); err != nil {
+		return err
+	}
+	m, err := newPhraseMatcher(oldTag, useRegex)
+	if err != nil {
+		return err
+	}
 	for _, id := range itemIDs {
 		for i, item := range a.items {
 			if item.ID == id {
-				var newTags []string
-				for _, t := range item.Tags {
-					if useRegex {
-						re, err := regexp.Compile(oldTag)
-						if err == nil {
-							newTags = append(newTags, re.ReplaceAllString(t, newTag))
-						} else {
-							newTags = append(newTags, t)
-						}
-					} else {
-						if t == oldTag {
-							newTags = append(newTags, newTag)
-						} else {
-							newTags = append(newTags, t)
-						}
-					}
-				}
-				a.items[i].RawTags = strings.Join(newTags, ", ")
-				a.items[i].Tags = newTags
+				// 开头的触发词不会被改写或删除
+				protected := protectedTagCount(item.Tags, a.keepTokens(item))
+				newTags := replaceCaptionTag(item.RawTags, a.config.Caption, m, newTag, weightMode, protected)
+				a.setItemCaption(i, newTags)
 				a.items[i].Modified = true
 			}
 		}
@@ -653,11 +600,18 @@ func (a *App) BatchReplaceTag(itemIDs []string, oldTag string, newTag string, us
 
 // GetItems returns all items
 func (a *App) GetItems() []DatasetItem {
-	return a.items
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]DatasetItem(nil), a.items...)
 }
 
-// FilterByTag returns items containing a specific tag/phrase (substring match)
+// FilterByTag returns items containing a specific tag/phrase (substring match
+// on the primary caption chosen by the pairing rules)
 func (a *App) FilterByTag(tag string) []DatasetItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	result := make([]DatasetItem, 0)
 	for _, item := range a.items {
 		// 使用子串匹配，因为标签现在是共同短语
@@ -668,8 +622,28 @@ func (a *App) FilterByTag(tag string) []DatasetItem {
 	return result
 }
 
+// FilterByExactTag returns the items whose parsed caption tags include tag.
+// Tags are compared whole and without emphasis, so "cat" does not match
+// "catgirl".
+func (a *App) FilterByExactTag(tag string) []DatasetItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tag = bareTag(tag)
+	result := make([]DatasetItem, 0)
+	for _, item := range a.items {
+		if containsString(item.Tags, tag) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 // GetItemByID returns a single item by ID
 func (a *App) GetItemByID(id string) *DatasetItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for _, item := range a.items {
 		if item.ID == id {
 			return &item
@@ -680,7 +654,7 @@ func (a *App) GetItemByID(id string) *DatasetItem {
 
 // ReadMediaFile reads and returns media file as base64 (for full preview)
 func (a *App) ReadMediaFile(path string) string {
-	data, err := os.ReadFile(path)
+	data, err := a.currentSource().ReadFile(path)
 	if err != nil {
 		return ""
 	}
@@ -708,7 +682,7 @@ func (a *App) ReadMediaFile(path string) string {
 
 // ReadTextFile reads a text file and returns its content
 func (a *App) ReadTextFile(path string) (string, error) {
-	data, err := os.ReadFile(path)
+	data, err := a.currentSource().ReadFile(path)
 	if err != nil {
 		return "", err
 	}
@@ -722,6 +696,9 @@ func (a *App) WriteTextFile(path string, content string) error {
 
 // GetPagedItems returns items for pagination
 func (a *App) GetPagedItems(page int, pageSize int) ([]DatasetItem, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	total := len(a.items)
 	start := (page - 1) * pageSize
 	if start >= total {
@@ -733,7 +710,7 @@ func (a *App) GetPagedItems(page int, pageSize int) ([]DatasetItem, int) {
 		end = total
 	}
 
-	return a.items[start:end], total
+	return append([]DatasetItem(nil), a.items[start:end]...), total
 }
 
 // OpenInExplorer opens the file location in explorer
@@ -748,15 +725,22 @@ func (a *App) OpenInExplorer(path string) error {
 
 // StreamFile streams large files efficiently
 func (a *App) StreamFile(path string, offset int64, length int64) ([]byte, error) {
-	file, err := os.Open(path)
+	file, err := a.currentSource().Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	file.Seek(offset, io.SeekStart)
+	if seeker, ok := file.(io.Seeker); ok {
+		seeker.Seek(offset, io.SeekStart)
+	} else if _, err := io.CopyN(io.Discard, file, offset); err != nil {
+		return nil, err
+	}
 	data := make([]byte, length)
-	n, err := file.Read(data)
+	n, err := io.ReadFull(file, data)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
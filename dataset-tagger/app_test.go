@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+// wantItems checks that got holds the items of names, in order
+func wantItems(t *testing.T, app *App, call string, got []DatasetItem, names []string) {
+	t.Helper()
+	if len(got) != len(names) {
+		t.Errorf("%s = %d items, want %d", call, len(got), len(names))
+		return
+	}
+	for i, name := range names {
+		if want := testItem(t, app, name).ID; got[i].ID != want {
+			t.Errorf("%s[%d] = %s, want %s", call, i, got[i].MediaPath, name)
+		}
+	}
+}
+
+func TestFilterByTagMatchesPhrases(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":     "",
+		"a.txt":     "1girl, cat, smile",
+		"b.png":     "",
+		"b.txt":     "A girl holding a cat, smiling at the camera.",
+		"c.png":     "",
+		"c.txt":     "outdoors",
+		"c.caption": "a cat in the garden",
+		datasetConfigFile: `{"pairing": {"captionExts": [".caption", ".txt"], "primaryCaption": ".caption"},
+			"caption": {"mode": "natural"}}`,
+	})
+	app := scanTestDataset(t, root)
+
+	for _, tt := range []struct {
+		phrase string
+		want   []string
+	}{
+		{"cat", []string{"a.png", "b.png", "c.png"}},
+		{"holding a cat", []string{"b.png"}},
+		{"in the garden", []string{"c.png"}},
+		// 只匹配主标注文件
+		{"outdoors", nil},
+	} {
+		wantItems(t, app, "FilterByTag("+tt.phrase+")", app.FilterByTag(tt.phrase), tt.want)
+	}
+}
+
+func TestFilterByExactTagMatchesWholeTags(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "1girl, cat, smile",
+		"b.png": "",
+		"b.txt": "1girl, catgirl",
+		"c.png": "",
+		"c.txt": "(cat:1.2), outdoors",
+	})
+	app := scanTestDataset(t, root)
+
+	for _, tt := range []struct {
+		tag  string
+		want []string
+	}{
+		{"cat", []string{"a.png", "c.png"}},
+		{"(cat:1.3)", []string{"a.png", "c.png"}},
+		{"catgirl", []string{"b.png"}},
+		{"girl", nil},
+	} {
+		wantItems(t, app, "FilterByExactTag("+tt.tag+")", app.FilterByExactTag(tt.tag), tt.want)
+	}
+}
@@ -0,0 +1,773 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// EventDatasetRelocated is emitted when an archived dataset was extracted before
+// saving and now lives in a folder; the payload maps "from" and "to" paths
+const EventDatasetRelocated = "dataset:relocated"
+
+// Archive save modes: where edits to an archived dataset are written
+const (
+	// ArchiveSaveExtract extracts the archive once to a sibling folder and continues there
+	ArchiveSaveExtract = "extract"
+	// ArchiveSaveRewrite writes captions back into the archive, replacing it atomically
+	ArchiveSaveRewrite = "rewrite"
+)
+
+// Archive formats understood by openArchive
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat returns the archive format of path from its extension, or ""
+func archiveFormat(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz
+	}
+	return ""
+}
+
+// isArchiveFile reports whether path is a regular file with an archive extension
+func isArchiveFile(path string) bool {
+	if archiveFormat(path) == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// archiveExtractDir is the sibling folder an archive is extracted to: set.tar.gz -> set
+func archiveExtractDir(archivePath string) string {
+	base := filepath.Base(archivePath)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	return filepath.Join(filepath.Dir(archivePath), base)
+}
+
+// archiveEntry is one regular file inside an archive
+type archiveEntry struct {
+	name    string // slash-separated name inside the archive
+	size    int64
+	modTime time.Time
+	zipFile *zip.File
+	offset  int64 // data offset of a plain tar entry
+	// data holds a small tar.gz entry whole and head the header bytes of a larger
+	// tar.gz image, so scanning does not decompress the archive once per file
+	data   []byte
+	cached bool
+	head   []byte
+}
+
+// maxCachedEntry is the largest tar.gz entry kept whole in memory, enough for
+// captions and sidecars
+const maxCachedEntry = 64 << 10
+
+// cache keeps what scanning reads of a tar.gz entry while the archive is read
+// once at open: small files whole, and of images the bytes image.DecodeConfig
+// consumes. Reading past them decompresses the archive up to the entry again.
+func (e *archiveEntry) cache(r io.Reader) error {
+	if e.size <= maxCachedEntry {
+		data, err := io.ReadAll(r)
+		e.data, e.cached = data, err == nil
+		return err
+	}
+	if imageExts[strings.ToLower(path.Ext(e.name))] {
+		var head bytes.Buffer
+		// 解析失败时也保留已读到的字节
+		image.DecodeConfig(io.TeeReader(r, &head))
+		e.head = head.Bytes()
+	}
+	return nil
+}
+
+// archiveFileInfo is the os.FileInfo of an archive entry or synthesized directory
+type archiveFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi archiveFileInfo) Name() string       { return fi.name }
+func (fi archiveFileInfo) Size() int64        { return fi.size }
+func (fi archiveFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi archiveFileInfo) IsDir() bool        { return fi.dir }
+func (fi archiveFileInfo) Sys() interface{}   { return nil }
+func (fi archiveFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+// archiveSource serves a zip or tar archive as a read-only directory tree rooted at
+// the archive path. Paths outside the archive are read from the local filesystem.
+type archiveSource struct {
+	path    string
+	format  string
+	modTime time.Time
+	entries map[string]*archiveEntry // by name inside the archive
+	dirs    map[string][]os.FileInfo // children by directory name ("" is the root)
+	zip     *zip.ReadCloser
+}
+
+// openArchive indexes the entries of an archive without extracting it
+func openArchive(archivePath string) (*archiveSource, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	s := &archiveSource{
+		path:    archivePath,
+		format:  archiveFormat(archivePath),
+		modTime: info.ModTime(),
+		entries: make(map[string]*archiveEntry),
+		dirs:    map[string][]os.FileInfo{"": {}},
+	}
+
+	switch s.format {
+	case archiveZip:
+		s.zip, err = zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range s.zip.File {
+			if f.FileInfo().IsDir() {
+				s.addDir(f.Name)
+				continue
+			}
+			s.addEntry(&archiveEntry{name: f.Name, size: int64(f.UncompressedSize64), modTime: f.Modified, zipFile: f})
+		}
+	case archiveTar, archiveTarGz:
+		err = s.eachTarEntry(func(hdr *tar.Header, offset int64, r io.Reader) (bool, error) {
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				s.addDir(hdr.Name)
+			case tar.TypeReg:
+				e := &archiveEntry{name: hdr.Name, size: hdr.Size, modTime: hdr.ModTime, offset: offset}
+				if s.format == archiveTarGz {
+					if err := e.cache(r); err != nil {
+						return false, err
+					}
+				}
+				s.addEntry(e)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive: %s", archivePath)
+	}
+
+	for _, children := range s.dirs {
+		sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	}
+	return s, nil
+}
+
+// cleanEntryName normalises an archive name; names escaping the root yield ""
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimPrefix(name, "/")
+}
+
+func (s *archiveSource) addDir(name string) {
+	name = cleanEntryName(name)
+	if name == "" {
+		return
+	}
+	if _, ok := s.dirs[name]; ok {
+		return
+	}
+	s.dirs[name] = []os.FileInfo{}
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	s.addDir(parent)
+	s.dirs[parent] = append(s.dirs[parent], archiveFileInfo{name: path.Base(name), modTime: s.modTime, dir: true})
+}
+
+func (s *archiveSource) addEntry(e *archiveEntry) {
+	e.name = cleanEntryName(e.name)
+	if e.name == "" || s.entries[e.name] != nil {
+		return
+	}
+	s.entries[e.name] = e
+	parent := path.Dir(e.name)
+	if parent == "." {
+		parent = ""
+	}
+	s.addDir(parent)
+	s.dirs[parent] = append(s.dirs[parent], archiveFileInfo{name: path.Base(e.name), size: e.size, modTime: e.modTime})
+}
+
+// eachTarEntry streams the tar entries; fn receives the data offset of each entry
+// (only meaningful for uncompressed tar) and returns false to stop
+func (s *archiveSource) eachTarEntry(fn func(hdr *tar.Header, offset int64, data io.Reader) (bool, error)) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	counter := &countingReader{r: f}
+	var r io.Reader = counter
+	if s.format == archiveTarGz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		more, err := fn(hdr, counter.n, tr)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// countingReader tracks the offset reached in a plain tar file
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// entryName maps an OS path to an archive name; ok is false outside the archive
+func (s *archiveSource) entryName(p string) (string, bool) {
+	if p == s.path {
+		return "", true
+	}
+	prefix := s.path + string(filepath.Separator)
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return filepath.ToSlash(p[len(prefix):]), true
+}
+
+func (s *archiveSource) ReadDir(dir string) ([]os.FileInfo, error) {
+	name, ok := s.entryName(dir)
+	if !ok {
+		return osSource{}.ReadDir(dir)
+	}
+	children, ok := s.dirs[name]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+	}
+	return append([]os.FileInfo(nil), children...), nil
+}
+
+func (s *archiveSource) Stat(p string) (os.FileInfo, error) {
+	name, ok := s.entryName(p)
+	if !ok {
+		return os.Stat(p)
+	}
+	if e := s.entries[name]; e != nil {
+		return archiveFileInfo{name: path.Base(name), size: e.size, modTime: e.modTime}, nil
+	}
+	if _, ok := s.dirs[name]; ok {
+		return archiveFileInfo{name: filepath.Base(p), modTime: s.modTime, dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+}
+
+// RealPath returns p unchanged inside the archive; archives contain no symlink loops
+func (s *archiveSource) RealPath(p string) (string, error) {
+	if _, ok := s.entryName(p); ok {
+		return p, nil
+	}
+	return filepath.EvalSymlinks(p)
+}
+
+func (s *archiveSource) Open(p string) (io.ReadCloser, error) {
+	name, ok := s.entryName(p)
+	if !ok {
+		return os.Open(p)
+	}
+	e := s.entries[name]
+	if e == nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+
+	switch {
+	case e.zipFile != nil:
+		return e.zipFile.Open()
+	case s.format == archiveTar:
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(e.offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, e.size), f}, nil
+	}
+
+	if e.cached {
+		return io.NopCloser(bytes.NewReader(e.data)), nil
+	}
+	return io.NopCloser(io.MultiReader(bytes.NewReader(e.head), &tarGzTail{s: s, e: e})), nil
+}
+
+// tarGzTail reads a tar.gz entry past its cached head. gzip cannot seek, so the
+// archive is decompressed from the start up to the entry, only once the head is
+// used up.
+type tarGzTail struct {
+	s *archiveSource
+	e *archiveEntry
+	r io.Reader
+}
+
+func (t *tarGzTail) Read(p []byte) (int, error) {
+	if t.r == nil {
+		data, err := t.s.readTarGzEntry(t.e.name)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) < len(t.e.head) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		t.r = bytes.NewReader(data[len(t.e.head):])
+	}
+	return t.r.Read(p)
+}
+
+// readTarGzEntry decompresses the archive up to the entry name and returns its data
+func (s *archiveSource) readTarGzEntry(name string) ([]byte, error) {
+	var data []byte
+	err := s.eachTarEntry(func(hdr *tar.Header, _ int64, r io.Reader) (bool, error) {
+		if hdr.Typeflag != tar.TypeReg || cleanEntryName(hdr.Name) != name {
+			return true, nil
+		}
+		var err error
+		data, err = io.ReadAll(r)
+		return false, err
+	})
+	return data, err
+}
+
+func (s *archiveSource) ReadFile(p string) ([]byte, error) {
+	f, err := s.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *archiveSource) Close() error {
+	if s.zip == nil {
+		return nil
+	}
+	err := s.zip.Close()
+	s.zip = nil
+	return err
+}
+
+// extractTo writes every entry below dir, which must not exist yet
+func (s *archiveSource) extractTo(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists; open that folder instead of the archive", dir)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	write := func(name string, r io.Reader, modTime time.Time) error {
+		target := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(target, modTime, modTime)
+	}
+
+	for name := range s.dirs {
+		if err := os.MkdirAll(filepath.Join(tmp, filepath.FromSlash(name)), 0755); err != nil {
+			return err
+		}
+	}
+	if s.format == archiveTarGz {
+		// 一次顺序解压，避免每个条目都从头读取
+		err = s.eachTarEntry(func(hdr *tar.Header, _ int64, r io.Reader) (bool, error) {
+			name := cleanEntryName(hdr.Name)
+			if hdr.Typeflag != tar.TypeReg || s.entries[name] == nil {
+				return true, nil
+			}
+			return true, write(name, r, hdr.ModTime)
+		})
+	} else {
+		for name, e := range s.entries {
+			r, err := s.Open(filepath.Join(s.path, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			err = write(name, r, e.modTime)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// rewrite replaces the archive with a copy in which the files in changes (by archive
+// name) are replaced or added. The new archive is written next to the old one and
+// renamed over it, so a failed write leaves the original intact.
+func (s *archiveSource) rewrite(changes map[string][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if s.format == archiveZip {
+		err = s.rewriteZip(tmp, changes)
+	} else {
+		err = s.rewriteTar(tmp, changes)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *archiveSource) rewriteZip(out io.Writer, changes map[string][]byte) error {
+	zw := zip.NewWriter(out)
+	done := make(map[string]bool)
+	for _, f := range s.zip.File {
+		name := cleanEntryName(f.Name)
+		data, changed := changes[name]
+		if !changed || f.FileInfo().IsDir() {
+			// 未修改的条目直接复制压缩数据，不重新压缩
+			r, err := f.OpenRaw()
+			if err != nil {
+				return err
+			}
+			w, err := zw.CreateRaw(&f.FileHeader)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, r); err != nil {
+				return err
+			}
+			continue
+		}
+		done[name] = true
+		hdr := f.FileHeader
+		hdr.Modified = time.Now()
+		w, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedChangeNames(changes, done) {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(changes[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (s *archiveSource) rewriteTar(out io.Writer, changes map[string][]byte) error {
+	var gz *gzip.Writer
+	if s.format == archiveTarGz {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+	done := make(map[string]bool)
+	err := s.eachTarEntry(func(hdr *tar.Header, _ int64, r io.Reader) (bool, error) {
+		name := cleanEntryName(hdr.Name)
+		data, changed := changes[name]
+		if !changed || hdr.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return false, err
+			}
+			_, err := io.Copy(tw, r)
+			return err == nil, err
+		}
+		done[name] = true
+		copied := *hdr
+		copied.Size = int64(len(data))
+		copied.ModTime = time.Now()
+		if err := tw.WriteHeader(&copied); err != nil {
+			return false, err
+		}
+		_, err := tw.Write(data)
+		return err == nil, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedChangeNames(changes, done) {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(changes[name])), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(changes[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// sortedChangeNames lists the changed files that are not yet in the archive
+func sortedChangeNames(changes map[string][]byte, done map[string]bool) []string {
+	names := make([]string, 0)
+	for name := range changes {
+		if !done[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// extractTempFile copies an archive entry to a temporary file for external tools
+func extractTempFile(s *archiveSource, p string) (string, error) {
+	r, err := s.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	out, err := os.CreateTemp("", "dataset-tagger-*"+filepath.Ext(p))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// SelectArchive opens a file dialog for a zipped or tarred dataset
+func (a *App) SelectArchive() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "选择数据集压缩包",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "压缩包 (*.zip;*.tar;*.tar.gz;*.tgz)", Pattern: "*.zip;*.tar;*.tar.gz;*.tgz"},
+		},
+	})
+}
+
+// loadedArchive returns the archive backing the dataset, or nil for a folder.
+// The caller must hold a.mu.
+func (a *App) loadedArchive() *archiveSource {
+	s, _ := a.source.(*archiveSource)
+	return s
+}
+
+// GetArchiveSaveMode returns where edits to an archived dataset are written
+func (a *App) GetArchiveSaveMode() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.archiveSave
+}
+
+// SetArchiveSaveMode chooses where edits to an archived dataset are written:
+// "extract" (a sibling folder named after the archive) or "rewrite" (the archive itself)
+func (a *App) SetArchiveSaveMode(mode string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if mode != ArchiveSaveExtract && mode != ArchiveSaveRewrite {
+		return fmt.Errorf("unknown archive save mode: %s", mode)
+	}
+	a.archiveSave = mode
+	return nil
+}
+
+// prepareWrite readies the dataset for changes on disk; the caller must hold a.mu.
+// In extract mode an archived dataset is extracted once and moved to the extracted
+// folder; the returned function maps paths from before the move to paths after it.
+// files is true when media or caption files are deleted or renamed, which is not
+// possible inside an archive.
+func (a *App) prepareWrite(files bool) (func(string) string, error) {
+	same := func(p string) string { return p }
+	archive := a.loadedArchive()
+	if archive == nil {
+		return same, nil
+	}
+	if a.archiveSave == ArchiveSaveRewrite {
+		if files {
+			return same, fmt.Errorf("deleting or renaming files inside an archive is not supported; use the extract save mode")
+		}
+		return same, nil
+	}
+
+	dir := archiveExtractDir(archive.path)
+	if err := archive.extractTo(dir); err != nil {
+		return same, err
+	}
+	rebase := func(p string) string {
+		if p == archive.path {
+			return dir
+		}
+		if strings.HasPrefix(p, archive.path+string(filepath.Separator)) {
+			return dir + p[len(archive.path):]
+		}
+		return p
+	}
+	for i := range a.items {
+		item := &a.items[i]
+		item.ID = rebase(item.ID)
+		item.MediaPath = rebase(item.MediaPath)
+		item.ConceptDir = rebase(item.ConceptDir)
+		if item.TxtPath != "" {
+			item.TxtPath = rebase(item.TxtPath)
+		}
+		for j, sidecar := range item.Sidecars {
+			item.Sidecars[j] = rebase(sidecar)
+		}
+	}
+	for i := range a.issues {
+		a.issues[i].Path = rebase(a.issues[i].Path)
+		for j, related := range a.issues[i].Related {
+			a.issues[i].Related[j] = rebase(related)
+		}
+	}
+	archive.Close()
+	a.source = osSource{}
+	a.datasetPath = dir
+	a.pairing = newPairer(dir, a.config.Pairing).ignoring(a.configFiles())
+	a.archiveChanges = nil
+	a.emit(EventDatasetRelocated, map[string]string{"from": archive.path, "to": dir})
+	return rebase, nil
+}
+
+// writeDatasetFile stores a caption or config file; inside an archive in rewrite mode
+// it is queued until flushArchive. The caller must hold a.mu.
+func (a *App) writeDatasetFile(p string, data []byte) error {
+	if archive := a.loadedArchive(); archive != nil {
+		name, ok := archive.entryName(p)
+		if !ok || name == "" {
+			return fmt.Errorf("%s is outside the archive", p)
+		}
+		if a.archiveChanges == nil {
+			a.archiveChanges = make(map[string][]byte)
+		}
+		a.archiveChanges[name] = data
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+// flushArchive rewrites the archive with the queued captions and reopens it.
+// The caller must hold a.mu.
+func (a *App) flushArchive() error {
+	archive := a.loadedArchive()
+	if archive == nil || len(a.archiveChanges) == 0 {
+		return nil
+	}
+	changes := a.archiveChanges
+	err := archive.rewrite(changes)
+	archive.Close()
+	if err == nil {
+		a.archiveChanges = nil
+	}
+
+	reopened, oerr := openArchive(archive.path)
+	if oerr != nil {
+		return oerr
+	}
+	a.source = reopened
+	return err
+}
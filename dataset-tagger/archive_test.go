@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// noisePNG encodes an image that does not compress, larger than maxCachedEntry
+func noisePNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() <= maxCachedEntry {
+		t.Fatalf("noise image is only %d bytes", buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func writeTarGz(t *testing.T, path string, files map[string][]byte, order []string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range order {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: time.Unix(1700000000, 0), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTarGzScanReadsArchiveOnce(t *testing.T) {
+	big := noisePNG(t)
+	var small bytes.Buffer
+	png.Encode(&small, image.NewGray(image.Rect(0, 0, 3, 2)))
+	files := map[string][]byte{
+		"set/a.png": big,
+		"set/a.txt": []byte("1girl, smile"),
+		"set/b.png": small.Bytes(),
+		"set/b.txt": []byte("1boy"),
+	}
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "set.tar.gz")
+	writeTarGz(t, archivePath, files, []string{"set/a.png", "set/a.txt", "set/b.png", "set/b.txt"})
+
+	t.Run("scan", func(t *testing.T) {
+		app := NewApp()
+		result := app.scanFolder(context.Background(), archivePath, nil)
+		if !result.Success || result.TotalItems != 2 {
+			t.Fatalf("scan = %+v", result)
+		}
+		if a := testItem(t, app, "set/a.png"); a.RawTags != "1girl, smile" || a.Width != 256 {
+			t.Fatalf("a = %q %dx%d", a.RawTags, a.Width, a.Height)
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		s, err := openArchive(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		// 打开后归档已不可读：扫描用到的内容必须都来自打开时的那一次读取
+		moved := archivePath + ".moved"
+		if err := os.Rename(archivePath, moved); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"set/a.txt", "set/b.txt", "set/b.png"} {
+			data, err := s.ReadFile(filepath.Join(archivePath, filepath.FromSlash(name)))
+			if err != nil || !bytes.Equal(data, files[name]) {
+				t.Fatalf("%s = %q, %v", name, data, err)
+			}
+		}
+		r, err := s.Open(filepath.Join(archivePath, "set", "a.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(r)
+		r.Close()
+		if err != nil || cfg.Width != 256 {
+			t.Fatalf("header of the large image = %+v, %v", cfg, err)
+		}
+
+		// 读到缓存之外时才重新解压
+		if err := os.Rename(moved, archivePath); err != nil {
+			t.Fatal(err)
+		}
+		data, err := s.ReadFile(filepath.Join(archivePath, "set", "a.png"))
+		if err != nil || !bytes.Equal(data, big) {
+			t.Fatalf("large image read back %d bytes, %v; want %d", len(data), err, len(big))
+		}
+	})
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Caption modes: how the text of a caption file is structured
+const (
+	// CaptionModeTags treats every line as a comma-separated tag list (booru style)
+	CaptionModeTags = "tags"
+	// CaptionModeNatural treats the whole caption as prose; it has no tags
+	CaptionModeNatural = "natural"
+	// CaptionModeMixed starts with tag-list lines followed by prose lines
+	CaptionModeMixed = "mixed"
+)
+
+// CaptionConfig describes the caption format of a dataset
+type CaptionConfig struct {
+	Mode string `json:"mode"`
+	// TagLines is the number of leading tag-list lines in mixed mode
+	TagLines int `json:"tagLines"`
+}
+
+func defaultCaptionConfig() CaptionConfig {
+	return CaptionConfig{Mode: CaptionModeTags, TagLines: 1}
+}
+
+func (c *CaptionConfig) normalize() {
+	c.Mode = strings.ToLower(strings.TrimSpace(c.Mode))
+	if c.Mode == "" {
+		c.Mode = CaptionModeTags
+	}
+	if c.TagLines < 1 {
+		c.TagLines = 1
+	}
+}
+
+func (c *CaptionConfig) validate() error {
+	switch c.Mode {
+	case CaptionModeTags, CaptionModeNatural, CaptionModeMixed:
+		return nil
+	}
+	return fmt.Errorf("unknown caption mode: %s", c.Mode)
+}
+
+// captionLine is one line of a caption and its role under the caption mode
+type captionLine struct {
+	text  string // without the line ending
+	eol   string // "\n", "\r\n" or "" for the last line
+	tags  bool   // a comma-separated tag list rather than prose
+	start int    // byte offset of text within the caption
+}
+
+// splitCaptionLines splits raw into lines, keeping line endings so the caption can
+// be reassembled byte for byte
+func splitCaptionLines(raw string, cfg CaptionConfig) []captionLine {
+	lines := make([]captionLine, 0)
+	offset := 0
+	for {
+		line := captionLine{text: raw, start: offset}
+		if i := strings.IndexByte(raw, '\n'); i != -1 {
+			line.text, line.eol, raw = raw[:i], "\n", raw[i+1:]
+			if strings.HasSuffix(line.text, "\r") {
+				line.text, line.eol = line.text[:len(line.text)-1], "\r\n"
+			}
+		}
+		offset += len(line.text) + len(line.eol)
+
+		switch cfg.Mode {
+		case CaptionModeNatural:
+		case CaptionModeMixed:
+			// 按行号划分，清空的标签行仍占位，描述行不会因此变成标签行
+			line.tags = len(lines) < cfg.TagLines
+		default:
+			line.tags = true
+		}
+		lines = append(lines, line)
+		if line.eol == "" {
+			return lines
+		}
+	}
+}
+
+func joinCaptionLines(lines []captionLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line.text)
+		b.WriteString(line.eol)
+	}
+	return b.String()
+}
+
+// tagSpan is the byte range of one trimmed tag; separators and surrounding
+// whitespace lie outside it
+type tagSpan struct {
+	start, end int
+}
+
+// tagSeparators separate the tags of a tag-list line
+const tagSeparators = ",，"
+
+// tagListSpans returns the spans of the non-empty tags in one tag-list line; tags
+// are separated by "," or the fullwidth "，"
+func tagListSpans(text string) []tagSpan {
+	spans := make([]tagSpan, 0)
+	for start := 0; start <= len(text); {
+		end, sepLen := len(text), 0
+		if i := strings.IndexAny(text[start:], tagSeparators); i != -1 {
+			end = start + i
+			_, sepLen = utf8.DecodeRuneInString(text[end:])
+		}
+		part := text[start:end]
+		trimmed := strings.TrimLeft(part, " \t")
+		from := start + len(part) - len(trimmed)
+		to := from + len(strings.TrimRight(trimmed, " \t"))
+		if to > from {
+			spans = append(spans, tagSpan{from, to})
+		}
+		if sepLen == 0 {
+			break
+		}
+		start = end + sepLen
+	}
+	return spans
+}
+
+// splitTagList splits one tag-list line into trimmed, non-empty tags
+func splitTagList(text string) []string {
+	spans := tagListSpans(text)
+	tags := make([]string, len(spans))
+	for i, span := range spans {
+		tags[i] = text[span.start:span.end]
+	}
+	return tags
+}
+
+// captionTags returns the bare tags of a caption, without SD emphasis syntax;
+// prose lines contribute none
+func captionTags(raw string, cfg CaptionConfig) []string {
+	tags := make([]string, 0)
+	for _, line := range splitCaptionLines(raw, cfg) {
+		if line.tags {
+			for _, text := range splitTagList(line.text) {
+				tags = append(tags, bareTag(text))
+			}
+		}
+	}
+	return tags
+}
+
+// captionEmpty reports whether a caption has neither tags nor prose
+func captionEmpty(raw string, cfg CaptionConfig) bool {
+	for _, line := range splitCaptionLines(raw, cfg) {
+		if line.tags && len(splitTagList(line.text)) > 0 {
+			return false
+		}
+		if !line.tags && strings.TrimSpace(line.text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// spanEdit replaces raw[start:end] with text
+type spanEdit struct {
+	start, end int
+	text       string
+}
+
+// applySpanEdits applies non-overlapping edits; every byte outside them is kept
+func applySpanEdits(raw string, edits []spanEdit) string {
+	if len(edits) == 0 {
+		return raw
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var b strings.Builder
+	last := 0
+	for _, e := range edits {
+		b.WriteString(raw[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(raw[last:])
+	return b.String()
+}
+
+// editCaptionTags passes every tag through edit, which returns the new tag text
+// and whether to keep it. Only the changed spans are rewritten: a removed tag
+// takes one neighbouring separator with it, so the separator style, line endings
+// and any trailing text stay byte-identical.
+func editCaptionTags(raw string, cfg CaptionConfig, edit func(tag string) (string, bool)) string {
+	edits := make([]spanEdit, 0)
+	lines := splitCaptionLines(raw, cfg)
+	for _, line := range lines {
+		if !line.tags {
+			continue
+		}
+		spans := tagListSpans(line.text)
+		texts := make([]string, len(spans))
+		keep := make([]bool, len(spans))
+		kept, changed := 0, false
+		for i, span := range spans {
+			tag := line.text[span.start:span.end]
+			texts[i], keep[i] = edit(tag)
+			texts[i] = strings.TrimSpace(texts[i])
+			if texts[i] == "" {
+				keep[i] = false
+			}
+			if keep[i] {
+				kept++
+			}
+			changed = changed || !keep[i] || texts[i] != tag
+		}
+		if !changed {
+			continue
+		}
+
+		if kept == 0 {
+			// 整行标签都被删除：标签模式下连同换行一起删掉；混合模式保留空行占位
+			end := line.start + len(line.text)
+			if cfg.Mode != CaptionModeMixed {
+				end += len(line.eol)
+			}
+			edits = append(edits, spanEdit{start: line.start, end: end})
+			continue
+		}
+
+		for i := 0; i < len(spans); {
+			if keep[i] {
+				if texts[i] != line.text[spans[i].start:spans[i].end] {
+					edits = append(edits, spanEdit{line.start + spans[i].start, line.start + spans[i].end, texts[i]})
+				}
+				i++
+				continue
+			}
+			j := i
+			for j < len(spans) && !keep[j] {
+				j++
+			}
+			if i > 0 {
+				// 带走前面的分隔符，后面的分隔符和标签之后的内容保持不变
+				edits = append(edits, spanEdit{start: line.start + spans[i-1].end, end: line.start + spans[j-1].end})
+			} else {
+				// 行首的标签删到下一个保留标签之前
+				edits = append(edits, spanEdit{start: line.start + spans[i].start, end: line.start + spans[j].start})
+			}
+			i = j
+		}
+	}
+	return applySpanEdits(raw, edits)
+}
+
+// editCaptionProse rewrites the text of every prose line through edit
+func editCaptionProse(raw string, cfg CaptionConfig, edit func(text string) string) string {
+	lines := splitCaptionLines(raw, cfg)
+	for i, line := range lines {
+		if !line.tags {
+			lines[i].text = edit(line.text)
+		}
+	}
+	return joinCaptionLines(lines)
+}
+
+const (
+	proseClauseSeps  = ",，;；"
+	proseSentenceEnd = ".!?。！？"
+)
+
+// removeProseSpan removes text[start:end] and closes the gap it leaves: at most
+// one separator or space is dropped next to the removed phrase, the rest of the
+// line is left untouched
+func removeProseSpan(text string, start, end int) string {
+	left, right := text[:start], text[end:]
+	lt := strings.TrimRight(left, " \t")
+	rt := strings.TrimLeft(right, " \t")
+	gap := left[len(lt):]
+	if gap == "" {
+		gap = right[:len(right)-len(rt)]
+	}
+
+	last, lastSize := utf8.DecodeLastRuneInString(lt)
+	first, firstSize := utf8.DecodeRuneInString(rt)
+	switch {
+	case lt == "":
+		// 句首：连同后面的分隔符一起删掉
+		if strings.ContainsRune(proseClauseSeps, first) {
+			rt = strings.TrimLeft(rt[firstSize:], " \t")
+		}
+		return left + rt
+	case rt == "":
+		// 行尾：删掉前面悬空的分隔符，保留原有的行尾空白
+		if strings.ContainsRune(proseClauseSeps, last) {
+			lt = lt[:len(lt)-lastSize]
+		}
+		return lt + right[len(right)-len(rt):]
+	case strings.ContainsRune(proseClauseSeps, last) && strings.ContainsRune(proseClauseSeps, first):
+		return lt + rt[firstSize:]
+	case strings.ContainsRune(proseClauseSeps, last) && strings.ContainsRune(proseSentenceEnd, first):
+		return lt[:len(lt)-lastSize] + rt
+	case strings.ContainsRune(proseClauseSeps+proseSentenceEnd+":：", first):
+		return lt + rt
+	}
+	return lt + gap + rt
+}
+
+// addCaptionTag inserts tag at the start ("prepend") or end of the caption's tag
+// section. Natural captions get the tag as a leading or trailing phrase.
+func addCaptionTag(raw string, cfg CaptionConfig, tag string, position string) string {
+	if strings.TrimSpace(raw) == "" {
+		return tag
+	}
+	lines := splitCaptionLines(raw, cfg)
+
+	target := -1
+	for i, line := range lines {
+		if line.tags && strings.TrimSpace(line.text) != "" {
+			target = i
+			if position == "prepend" {
+				break
+			}
+		}
+	}
+
+	if target == -1 {
+		if cfg.Mode == CaptionModeMixed {
+			// 标签行都是空的，写入第一行
+			lines[0].text = tag
+			return joinCaptionLines(lines)
+		}
+		// 自然语言：触发词放在开头，追加时作为结尾的短语
+		if position == "prepend" {
+			return tag + ", " + raw
+		}
+		return editLastProseLine(lines, func(text string) string {
+			trimmed := strings.TrimRight(text, " \t")
+			if last, _ := utf8.DecodeLastRuneInString(trimmed); strings.ContainsRune(proseSentenceEnd, last) {
+				return trimmed + " " + tag
+			}
+			return trimmed + ", " + tag
+		})
+	}
+
+	line := &lines[target]
+	if position == "prepend" {
+		indent := line.text[:len(line.text)-len(strings.TrimLeft(line.text, " \t"))]
+		line.text = indent + tag + ", " + line.text[len(indent):]
+	} else {
+		trimmed := strings.TrimRight(line.text, " \t")
+		switch last, _ := utf8.DecodeLastRuneInString(trimmed); {
+		case last == '，':
+			// 全角逗号自带间距
+			line.text = trimmed + tag
+		case strings.ContainsRune(tagSeparators, last):
+			line.text = trimmed + " " + tag
+		default:
+			line.text = trimmed + ", " + tag
+		}
+	}
+	return joinCaptionLines(lines)
+}
+
+// insertCaptionTag inserts tag before the index-th tag of the caption, counting
+// across tag lines. Index 0 is a plain prepend; an index past the last tag puts
+// the tag right after it.
+func insertCaptionTag(raw string, cfg CaptionConfig, tag string, index int) string {
+	if index <= 0 {
+		return addCaptionTag(raw, cfg, tag, "prepend")
+	}
+	seen, after := 0, -1
+	for _, line := range splitCaptionLines(raw, cfg) {
+		if !line.tags {
+			continue
+		}
+		for _, span := range tagListSpans(line.text) {
+			if seen == index {
+				at := line.start + span.start
+				return raw[:at] + tag + ", " + raw[at:]
+			}
+			after = line.start + span.end
+			seen++
+		}
+	}
+	if after == -1 {
+		return addCaptionTag(raw, cfg, tag, "prepend")
+	}
+	return raw[:after] + ", " + tag + raw[after:]
+}
+
+// editLastProseLine applies edit to the last non-empty line
+func editLastProseLine(lines []captionLine, edit func(text string) string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i].text) != "" {
+			lines[i].text = edit(lines[i].text)
+			break
+		}
+	}
+	return joinCaptionLines(lines)
+}
+
+// phraseMatcher matches a tag or prose phrase, literally or as a regular expression
+type phraseMatcher struct {
+	literal string
+	bare    string // literal without emphasis, compared with bare tags
+	re      *regexp.Regexp
+}
+
+func newPhraseMatcher(pattern string, useRegex bool) (*phraseMatcher, error) {
+	if !useRegex {
+		return &phraseMatcher{literal: pattern, bare: bareTag(pattern)}, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+	return &phraseMatcher{re: re}, nil
+}
+
+// matchTag reports whether a whole bare tag matches (regex matches anywhere in
+// the tag); "(blue eyes:1.2)" matches "blue eyes"
+func (m *phraseMatcher) matchTag(tag string) bool {
+	if m.re != nil {
+		return m.re.MatchString(tag)
+	}
+	return tag == m.bare
+}
+
+// replaceTag returns the replacement of a matching bare tag
+func (m *phraseMatcher) replaceTag(tag, repl string) string {
+	if m.re != nil {
+		return m.re.ReplaceAllString(tag, repl)
+	}
+	if tag == m.bare {
+		return repl
+	}
+	return tag
+}
+
+// replaceProse replaces every occurrence of the phrase in prose text
+func (m *phraseMatcher) replaceProse(text, repl string) string {
+	if m.re != nil {
+		return m.re.ReplaceAllString(text, repl)
+	}
+	if m.literal == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, m.literal, repl)
+}
+
+// findProse returns the byte ranges of every non-empty occurrence of the phrase
+func (m *phraseMatcher) findProse(text string) [][]int {
+	found := make([][]int, 0)
+	if m.re != nil {
+		for _, loc := range m.re.FindAllStringIndex(text, -1) {
+			if loc[1] > loc[0] {
+				found = append(found, loc)
+			}
+		}
+		return found
+	}
+	if m.literal == "" {
+		return found
+	}
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], m.literal)
+		if i == -1 {
+			return found
+		}
+		found = append(found, []int{offset + i, offset + i + len(m.literal)})
+		offset += i + len(m.literal)
+	}
+}
+
+// removeCaptionTag removes matching tags from tag lines except the first
+// protected tags; in natural mode the phrase is removed from the prose instead
+func removeCaptionTag(raw string, cfg CaptionConfig, m *phraseMatcher, protected int) string {
+	if cfg.Mode == CaptionModeNatural {
+		return editCaptionProse(raw, cfg, func(text string) string {
+			// 从后往前删，前面的位置不受影响
+			found := m.findProse(text)
+			for i := len(found) - 1; i >= 0; i-- {
+				text = removeProseSpan(text, found[i][0], found[i][1])
+			}
+			return text
+		})
+	}
+	seen := -1
+	return editCaptionTags(raw, cfg, func(tag string) (string, bool) {
+		seen++
+		return tag, seen < protected || !m.matchTag(bareTag(tag))
+	})
+}
+
+// replaceCaptionTag replaces matching tags in tag lines, except the first
+// protected tags; weightMode decides what happens to their emphasis unless repl
+// carries its own. In natural mode the phrase is replaced within the prose
+// instead.
+func replaceCaptionTag(raw string, cfg CaptionConfig, m *phraseMatcher, repl string, weightMode string, protected int) string {
+	if cfg.Mode == CaptionModeNatural {
+		if repl == "" {
+			return removeCaptionTag(raw, cfg, m, protected)
+		}
+		return editCaptionProse(raw, cfg, func(text string) string {
+			return m.replaceProse(text, repl)
+		})
+	}
+	// 替换为空等同于删除
+	seen := -1
+	return editCaptionTags(raw, cfg, func(text string) (string, bool) {
+		seen++
+		p := parsePromptTag(text)
+		if seen < protected || !m.matchTag(p.Tag) {
+			return text, true
+		}
+		tag := m.replaceTag(p.Tag, repl)
+		if parsePromptTag(tag).weighted() {
+			return tag, true
+		}
+		return p.render(tag, weightMode, false), true
+	})
+}
+
+// removeCaptionTagAt removes the index-th tag of the caption (counting across
+// tag lines) the same way batch removal does
+func removeCaptionTagAt(raw string, cfg CaptionConfig, index int) string {
+	seen := -1
+	return editCaptionTags(raw, cfg, func(tag string) (string, bool) {
+		seen++
+		return tag, seen != index
+	})
+}
+
+// reparseCaptions recomputes tags and exact-tag counts after the caption mode
+// changed; the caller must hold a.mu
+func (a *App) reparseCaptions() {
+	a.tagFrequency = make(map[string]int)
+	for i := range a.items {
+		a.items[i].Tags = a.parseTags(a.items[i].RawTags)
+		a.adjustTagFrequency(a.items[i].Tags, 1)
+	}
+	a.applySortOrder()
+}
+
+// RemoveCaptionTag removes the index-th tag from an unsaved caption in the editor,
+// keeping every other byte of the caption as it is
+func (a *App) RemoveCaptionTag(raw string, index int) string {
+	a.mu.Lock()
+	cfg := a.config.Caption
+	a.mu.Unlock()
+	return removeCaptionTagAt(raw, cfg, index)
+}
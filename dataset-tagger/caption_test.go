@@ -0,0 +1,171 @@
+package main
+
+import "testing"
+
+var (
+	tagsMode    = CaptionConfig{Mode: CaptionModeTags, TagLines: 1}
+	naturalMode = CaptionConfig{Mode: CaptionModeNatural, TagLines: 1}
+	mixedMode   = CaptionConfig{Mode: CaptionModeMixed, TagLines: 1}
+)
+
+func TestAddCaptionTag(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		raw      string
+		cfg      CaptionConfig
+		position string
+		want     string
+	}{
+		{"empty", "", tagsMode, "append", "solo"},
+		{"append", "1girl, smile", tagsMode, "append", "1girl, smile, solo"},
+		{"append after trailing comma", "1girl, smile,", tagsMode, "append", "1girl, smile, solo"},
+		{"append after trailing fullwidth comma", "1girl，smile，", tagsMode, "append", "1girl，smile，solo"},
+		{"append after fullwidth comma and space", "1girl，smile， ", tagsMode, "append", "1girl，smile，solo"},
+		{"prepend keeps indent", "  1girl, smile", tagsMode, "prepend", "  solo, 1girl, smile"},
+		{"natural sentence", "A girl smiling.", naturalMode, "append", "A girl smiling. solo"},
+		{"natural clause", "a girl smiling", naturalMode, "append", "a girl smiling, solo"},
+		{"natural chinese sentence", "一个女孩在微笑。", naturalMode, "append", "一个女孩在微笑。 solo"},
+		{"natural chinese question", "她在笑吗？  ", naturalMode, "append", "她在笑吗？ solo"},
+		{"natural prepend", "A girl smiling.", naturalMode, "prepend", "solo, A girl smiling."},
+		{"mixed appends to the tag line", "1girl\nA girl smiling。", mixedMode, "append", "1girl, solo\nA girl smiling。"},
+		{"mixed empty tag line", "\n一个女孩在微笑。", mixedMode, "append", "solo\n一个女孩在微笑。"},
+	} {
+		if got := addCaptionTag(tt.raw, tt.cfg, "solo", tt.position); got != tt.want {
+			t.Errorf("%s: addCaptionTag(%q) = %q, want %q", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func mustMatcher(t *testing.T, pattern string, useRegex bool) *phraseMatcher {
+	t.Helper()
+	m, err := newPhraseMatcher(pattern, useRegex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRemoveCaptionTag(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		raw  string
+		cfg  CaptionConfig
+		tag  string
+		want string
+	}{
+		{"middle", "1girl, smile, solo", tagsMode, "smile", "1girl, solo"},
+		{"first", "smile, 1girl, solo", tagsMode, "smile", "1girl, solo"},
+		{"last", "1girl, solo, smile", tagsMode, "smile", "1girl, solo"},
+		{"weighted", "1girl, (smile:1.2), solo", tagsMode, "smile", "1girl, solo"},
+		{"nested emphasis", "1girl, ((smile)), solo", tagsMode, "smile", "1girl, solo"},
+		{"weighted pattern", "1girl, smile, solo", tagsMode, "[smile]", "1girl, solo"},
+		{"trailing separator kept", "1girl, smile, solo,", tagsMode, "solo", "1girl, smile,"},
+		{"trailing whitespace kept", "1girl, smile,  \n", tagsMode, "smile", "1girl,  \n"},
+		{"no spaces", "1girl,smile,solo", tagsMode, "smile", "1girl,solo"},
+		{"fullwidth comma", "1girl，smile，solo", tagsMode, "smile", "1girl，solo"},
+		{"cjk tags", "女孩，微笑，长发", tagsMode, "微笑", "女孩，长发"},
+		{"adjacent", "1girl, smile, smile, solo", tagsMode, "smile", "1girl, solo"},
+		{"every tag on a line", "1girl, solo\nsmile\nblue eyes", tagsMode, "smile", "1girl, solo\nblue eyes"},
+		{"crlf lines", "1girl, smile\r\nsolo, smile\r\n", tagsMode, "smile", "1girl\r\nsolo\r\n"},
+		{"mixed keeps prose", "1girl, smile\nShe has a smile.", mixedMode, "smile", "1girl\nShe has a smile."},
+		{"mixed keeps emptied tag line", "smile\nShe has a smile.", mixedMode, "smile", "\nShe has a smile."},
+		{"natural middle clause", "a girl, smiling, in the park", naturalMode, "smiling", "a girl, in the park"},
+		{"natural sentence start", "Smiling, a girl sits.", naturalMode, "Smiling", "a girl sits."},
+		{"natural before period", "A girl sits, smiling.", naturalMode, "smiling", "A girl sits."},
+		{"natural chinese clause", "一个女孩，微笑着，坐在公园里。", naturalMode, "微笑着", "一个女孩，坐在公园里。"},
+		{"natural chinese before period", "一个女孩坐着，微笑着。", naturalMode, "微笑着", "一个女孩坐着。"},
+		{"natural lines", "A girl, smiling.\nSmiling, she waves.", naturalMode, "smiling", "A girl.\nSmiling, she waves."},
+	} {
+		if got := removeCaptionTag(tt.raw, tt.cfg, mustMatcher(t, tt.tag, false), 0); got != tt.want {
+			t.Errorf("%s: remove %q from %q = %q, want %q", tt.name, tt.tag, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveCaptionTagKeepsProtected(t *testing.T) {
+	got := removeCaptionTag("mychar, 1girl, mychar", tagsMode, mustMatcher(t, "mychar", false), 1)
+	if want := "mychar, 1girl"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestReplaceCaptionTagKeepsProtected(t *testing.T) {
+	m := mustMatcher(t, "mychar", false)
+	for _, tt := range []struct {
+		repl string
+		want string
+	}{
+		{"otherchar", "mychar, 1girl, otherchar"},
+		{"", "mychar, 1girl"},
+	} {
+		if got := replaceCaptionTag("mychar, 1girl, mychar", tagsMode, m, tt.repl, WeightKeep, 1); got != tt.want {
+			t.Errorf("replace with %q = %q, want %q", tt.repl, got, tt.want)
+		}
+	}
+}
+
+func TestBatchReplaceTagKeepsTriggerWord(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":           "",
+		"a.txt":           "mychar, 1girl, smile",
+		"b.png":           "",
+		"b.txt":           "1girl, mychar",
+		datasetConfigFile: `{"keepTokens": {"tokens": ["mychar"]}}`,
+	})
+	app := scanTestDataset(t, root)
+	ids := []string{testItem(t, app, "a.png").ID, testItem(t, app, "b.png").ID}
+
+	if err := app.BatchReplaceTag(ids, "mychar", "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := testItem(t, app, "a.png").RawTags; got != "mychar, 1girl, smile" {
+		t.Errorf("a = %q, want the trigger word kept", got)
+	}
+	// 不在开头的同名标签不受保护
+	if got := testItem(t, app, "b.png").RawTags; got != "1girl" {
+		t.Errorf("b = %q, want the tag removed", got)
+	}
+
+	if err := app.BatchReplaceTag(ids, "mychar|1girl", "x", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := testItem(t, app, "a.png").RawTags; got != "mychar, x, smile" {
+		t.Errorf("a = %q, want only the unprotected tag rewritten", got)
+	}
+}
+
+func TestReplaceCaptionTag(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		raw    string
+		cfg    CaptionConfig
+		old    string
+		repl   string
+		weight string
+		want   string
+	}{
+		{"plain", "1girl, smile, solo", tagsMode, "smile", "grin", WeightKeep, "1girl, grin, solo"},
+		{"keeps weight", "1girl, (smile:1.2), solo", tagsMode, "smile", "grin", WeightKeep, "1girl, (grin:1.2), solo"},
+		{"strips weight", "1girl, (smile:1.2), solo", tagsMode, "smile", "grin", WeightStrip, "1girl, grin, solo"},
+		{"replacement weight wins", "1girl, (smile:1.2)", tagsMode, "smile", "(grin:0.8)", WeightKeep, "1girl, (grin:0.8)"},
+		{"spacing kept", "1girl ,smile ,  solo", tagsMode, "smile", "grin", WeightKeep, "1girl ,grin ,  solo"},
+		{"fullwidth separators kept", "女孩，微笑，长发", tagsMode, "微笑", "大笑", WeightKeep, "女孩，大笑，长发"},
+		{"empty replacement removes", "1girl, smile, solo", tagsMode, "smile", "", WeightKeep, "1girl, solo"},
+		{"mixed prose untouched", "1girl, smile\nA smile.", mixedMode, "smile", "grin", WeightKeep, "1girl, grin\nA smile."},
+		{"natural phrase", "A girl with a smile.", naturalMode, "smile", "grin", WeightKeep, "A girl with a grin."},
+		{"natural chinese", "一个女孩在微笑。", naturalMode, "微笑", "大笑", WeightKeep, "一个女孩在大笑。"},
+	} {
+		got := replaceCaptionTag(tt.raw, tt.cfg, mustMatcher(t, tt.old, false), tt.repl, tt.weight, 0)
+		if got != tt.want {
+			t.Errorf("%s: replace %q with %q in %q = %q, want %q", tt.name, tt.old, tt.repl, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestApplySpanEditsKeepsOtherBytes(t *testing.T) {
+	raw := "a, b\r\nc"
+	got := applySpanEdits(raw, []spanEdit{{6, 7, "C"}, {0, 1, "A"}})
+	if want := "A, b\r\nC"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
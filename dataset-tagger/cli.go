@@ -0,0 +1,969 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliCommand is a headless subcommand that runs App logic without the Wails window
+type cliCommand struct {
+	usage string
+	run   func(app *App, fs *flag.FlagSet, args []string) int
+}
+
+// cliCommands lists every subcommand understood by runCLI
+var cliCommands = map[string]cliCommand{
+	"scan":      {"scan [-json] [-sort KEY] [-desc] [-phrase P] [-category C] <folder>", cliScan},
+	"stats":     {"stats [-json] [-limit N] [-mode auto|chars|words] [-min-docs N] [-max-ratio R] [-stopwords FILE] [-blocklist FILE] [-dictionary CSV [-format danbooru|e621]] [-tags [-normalized] [-category C] [-sort KEY] [-reverse] [-csv]] [-categories] <folder>", cliStats},
+	"add":       {"add [-json] [-filter S] [-position prepend|append] [-dry-run] -tag T <folder>", cliAdd},
+	"remove":    {"remove [-json] [-filter S] [-regex] [-dry-run] -tag T <folder>", cliRemove},
+	"replace":   {"replace [-json] [-filter S] [-regex] [-weight keep|explicit|strip] [-dry-run] -old A -new B <folder>", cliReplace},
+	"normalize": {"normalize [-json] [-filter S] [-steps LIST] [-underscores space|underscore] [-dry-run] <folder>", cliNormalize},
+	"reorder":   {"reorder [-json] [-filter S] -by frequency|category|alphabetical|priority [-priority LIST] [-dry-run] <folder>", cliReorder},
+	"rules":     {"rules [-json] [-filter S] [-import CSV -kind aliases|implications [-global] [-spaces]] [-fix [-dry-run]] <folder>", cliRules},
+	"keep":      {"keep [-json] [-filter S] [-tokens LIST] [-fix [-dry-run]] <folder>", cliKeep},
+	"validate":  {"validate [-json] <folder>", cliValidate},
+	"fix":       {"fix [-json] -kind K -action A <folder>", cliFix},
+	"concepts":  {"concepts [-json] [-add-trigger prepend|append] [-dry-run] <folder>", cliConcepts},
+	"cooccur":   {"cooccur [-json] [-filter S] [-where W] [-top N] [-tag T] [-min N] [-sort count|lift|pmi|conditional] [-csv|-graphml] <folder>", cliCooccur},
+	"compare":   {"compare [-json] [-filter S] [-where W] [-dir D] [-baseline-dir D | -baseline FOLDER] [-unit phrases|tags] [-metric llr|tfidf] [-min-docs N] [-limit N] <folder>", cliCompare},
+}
+
+// cliCommandOrder keeps the help output stable
+var cliCommandOrder = []string{"scan", "stats", "add", "remove", "replace", "normalize", "reorder", "rules", "keep", "validate", "fix", "concepts", "cooccur", "compare"}
+
+// isCLIInvocation reports whether the process was started as a headless subcommand
+func isCLIInvocation(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	_, ok := cliCommands[args[0]]
+	return ok
+}
+
+// runCLI dispatches a subcommand and returns the process exit code
+func runCLI(args []string) int {
+	name := args[0]
+	cmd, ok := cliCommands[name]
+	if !ok {
+		printCLIUsage(os.Stdout)
+		return 0
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dataset-tagger %s\n", cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	return cmd.run(NewApp(), fs, args[1:])
+}
+
+// printCLIUsage prints the list of subcommands
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: dataset-tagger <command> [flags] <folder>")
+	fmt.Fprintln(w, "\nRun without arguments to start the GUI. Commands:")
+	for _, name := range cliCommandOrder {
+		fmt.Fprintf(w, "  %s\n", cliCommands[name].usage)
+	}
+}
+
+// cliOptions holds the flags shared by every subcommand
+type cliOptions struct {
+	json bool
+}
+
+func (o *cliOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.json, "json", false, "print JSON instead of a table")
+}
+
+// parseCLIFolder parses flags and returns the dataset folder argument; flags
+// may come before or after the folder
+func parseCLIFolder(fs *flag.FlagSet, args []string) (string, bool) {
+	if err := fs.Parse(args); err != nil {
+		return "", false
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", false
+	}
+	folder := fs.Arg(0)
+	// flag 包遇到第一个非 flag 参数就停止解析，文件夹之后的 flag 需要再解析一次
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", false
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return "", false
+	}
+	return folder, true
+}
+
+// cliLoad scans the folder and reports failures on stderr; Ctrl-C cancels the scan
+func cliLoad(app *App, folder string) (ScanResult, bool) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result := app.scanFolder(ctx, folder, nil)
+	if !result.Success {
+		fmt.Fprintln(os.Stderr, result.Message)
+		return result, false
+	}
+	return result, true
+}
+
+// writeJSON prints v as indented JSON
+func writeJSON(w io.Writer, v interface{}) int {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newTable returns a tabwriter for aligned column output
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
+
+func cliScan(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	var order SortOrder
+	fs.StringVar(&order.Key, "sort", SortByPath, "sort key: "+strings.Join(sortKeys, ", "))
+	fs.BoolVar(&order.Descending, "desc", false, "sort in descending order")
+	fs.StringVar(&order.Phrase, "phrase", "", "phrase counted by -sort phrase_matches")
+	fs.StringVar(&order.Category, "category", "", "tag category counted by -sort category")
+	where := fs.String("where", "", `only list items matching conditions, e.g. "width<1024 aspect>2"`)
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if _, err := parseFilterConditions(*where); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	result, ok := cliLoad(app, folder)
+	if !ok {
+		return 1
+	}
+	if _, err := app.SetSortOrder(order); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	items, err := app.FilterItems("", *where)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	result.Items = items
+	if opts.json {
+		return writeJSON(os.Stdout, result)
+	}
+
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "MEDIA\tCAPTION\tTAGS\tSIZE\tBYTES")
+	for _, item := range result.Items {
+		caption := item.TxtPath
+		if caption == "" {
+			caption = "-"
+		}
+		size := "-"
+		if item.Width > 0 {
+			size = fmt.Sprintf("%dx%d", item.Width, item.Height)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\n", item.MediaPath, caption, len(item.Tags), size, item.Bytes)
+	}
+	tw.Flush()
+	if *where != "" {
+		fmt.Printf("\n%d of %d items match %s\n", len(result.Items), result.TotalItems, *where)
+		return 0
+	}
+	fmt.Printf("\n%d items (%d images, %d videos)\n", result.TotalItems, result.TotalImages, result.TotalVideos)
+	return 0
+}
+
+func cliStats(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	limit := fs.Int("limit", 0, "show at most N phrases or tags (0 = the dataset's phrase limit, all tags)")
+	exact := fs.Bool("tags", false, "count exact comma-separated tags instead of common phrases")
+	mode := fs.String("mode", "", "count common phrases by chars, words, or auto per caption (default: dataset setting)")
+	minDocs := fs.Int("min-docs", 0, "only list phrases found in at least N captions (default: dataset setting)")
+	maxRatio := fs.Float64("max-ratio", 0, "skip phrases found in more than this share of the captions (default: dataset setting)")
+	stopwords := fs.String("stopwords", "", "text file of extra stopwords, one per line (default: dataset setting)")
+	blocklist := fs.String("blocklist", "", "text file of phrases never listed, one per line (default: dataset setting)")
+	normalized := fs.Bool("normalized", false, "with -tags, count tags through the dataset's normalization pipeline")
+	category := fs.String("category", "", "with -tags, only list tags of this dictionary category (character, copyright, artist, general, meta, unknown)")
+	sortBy := fs.String("sort", TagSortCount, "with -tags, sort by "+strings.Join(tagSorts, ", "))
+	reverse := fs.Bool("reverse", false, "with -tags, reverse the sort order")
+	csvOut := fs.Bool("csv", false, "with -tags, print CSV with document share and first/last items")
+	categories := fs.Bool("categories", false, "print the per-category breakdown of the tags instead")
+	dictionary := fs.String("dictionary", "", "use this tags.csv instead of the dataset's configured dictionary")
+	format := fs.String("format", DictionaryDanbooru, "format of -dictionary: danbooru or e621")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	result, ok := cliLoad(app, folder)
+	if !ok {
+		return 1
+	}
+	if *mode != "" || *minDocs > 0 || *maxRatio > 0 || *limit > 0 || *stopwords != "" || *blocklist != "" {
+		// 只影响这次统计，不写入数据集配置
+		cfg := app.phraseConfig
+		if *mode != "" {
+			cfg.Mode = *mode
+		}
+		if *minDocs > 0 {
+			cfg.MinDocs = *minDocs
+		}
+		if *maxRatio > 0 {
+			cfg.MaxDocRatio = *maxRatio
+		}
+		if *limit > 0 {
+			cfg.Limit = *limit
+		}
+		for _, f := range []struct {
+			flag  string
+			field *string
+		}{{*stopwords, &cfg.Stopwords}, {*blocklist, &cfg.Blocklist}} {
+			if f.flag == "" {
+				continue
+			}
+			path, err := filepath.Abs(f.flag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			*f.field = path
+		}
+		cfg.normalize()
+		if err := cfg.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		filter, err := loadPhraseFilter(osSource{}, folder, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		app.phraseConfig = cfg
+		app.phraseFilter = filter
+		result.Tags = app.analyzeCommonPhrases()
+	}
+	if *dictionary != "" {
+		// 只影响这次统计，不写入数据集配置
+		path, err := filepath.Abs(*dictionary)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		cfg := DictionaryConfig{Path: path, Format: *format}
+		cfg.normalize()
+		if err := cfg.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		d, err := loadTagDictionary(osSource{}, folder, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		app.dictionary = d
+		app.categorizeTags(result.Tags)
+		result.Categories = app.categoryStats()
+	}
+	if *categories {
+		if opts.json {
+			return writeJSON(os.Stdout, result.Categories)
+		}
+		tw := newTable(os.Stdout)
+		fmt.Fprintln(tw, "CATEGORY\tTAGS\tCOUNT")
+		for _, c := range result.Categories {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", c.Category, c.Tags, c.Count)
+		}
+		tw.Flush()
+		return 0
+	}
+
+	if *exact {
+		stats, err := app.GetTagStats(TagStatsOptions{Normalized: *normalized, Category: *category, Sort: *sortBy, Reverse: *reverse})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *limit > 0 && len(stats) > *limit {
+			stats = stats[:*limit]
+		}
+		switch {
+		case opts.json:
+			return writeJSON(os.Stdout, stats)
+		case *csvOut:
+			if err := writeTagStatsCSV(os.Stdout, stats, app.datasetPath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return 0
+		}
+		tw := newTable(os.Stdout)
+		fmt.Fprintln(tw, "COUNT\tDOCS\tSHARE\tCATEGORY\tTAG")
+		for _, t := range stats {
+			fmt.Fprintf(tw, "%d\t%d\t%.1f%%\t%s\t%s\n", t.Count, t.Docs, t.Share*100, t.Category, t.Tag)
+		}
+		tw.Flush()
+		return 0
+	}
+
+	tags := result.Tags
+	if *limit > 0 && len(tags) > *limit {
+		tags = tags[:*limit]
+	}
+	if opts.json {
+		return writeJSON(os.Stdout, tags)
+	}
+
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "COUNT\tCATEGORY\tPHRASE")
+	for _, t := range tags {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", t.Count, t.Category, t.Tag)
+	}
+	tw.Flush()
+	return 0
+}
+
+// cliBatchOptions holds the flags shared by the batch edit subcommands
+type cliBatchOptions struct {
+	cliOptions
+	filter      string
+	where       string
+	dryRun      bool
+	archiveSave string
+}
+
+func (o *cliBatchOptions) register(fs *flag.FlagSet) {
+	o.cliOptions.register(fs)
+	fs.StringVar(&o.filter, "filter", "", "only edit items whose caption contains this phrase")
+	fs.StringVar(&o.where, "where", "", `only edit items matching conditions, e.g. "width<1024 format=png"`)
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print the changes without writing caption files")
+	registerArchiveSave(fs, &o.archiveSave)
+}
+
+// registerArchiveSave adds the flag choosing where edits to an archive are saved
+func registerArchiveSave(fs *flag.FlagSet, mode *string) {
+	fs.StringVar(mode, "archive-save", ArchiveSaveExtract, "when the dataset is a .zip/.tar archive: extract to a sibling folder, or rewrite the archive")
+}
+
+// cliTargetIDs returns the IDs of the items a batch subcommand applies to
+func cliTargetIDs(app *App, opts cliBatchOptions) ([]string, error) {
+	items, err := app.FilterItems(opts.filter, opts.where)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+// cliRunBatch applies edit to the target items, then saves and reports the changes
+func cliRunBatch(app *App, opts cliBatchOptions, edit func(ids []string) error) int {
+	ids, err := cliTargetIDs(app, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	before := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if item := app.GetItemByID(id); item != nil {
+			before[id] = item.RawTags
+		}
+	}
+
+	if err := edit(ids); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	changes := make([]CaptionChange, 0)
+	for i, item := range app.items {
+		old, ok := before[item.ID]
+		if !ok {
+			continue
+		}
+		if old == item.RawTags {
+			// 内容未变化，不必重写文件
+			app.items[i].Modified = false
+			continue
+		}
+		changes = append(changes, CaptionChange{ID: item.ID, Path: item.MediaPath, Before: old, After: item.RawTags})
+	}
+
+	if !opts.dryRun {
+		if err := app.SetArchiveSaveMode(opts.archiveSave); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := app.SaveAllChanges(app.items); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	if opts.json {
+		return writeJSON(os.Stdout, map[string]interface{}{
+			"matched": len(ids),
+			"changed": len(changes),
+			"dryRun":  opts.dryRun,
+			"changes": changes,
+		})
+	}
+
+	for _, c := range changes {
+		fmt.Printf("%s\n  - %s\n  + %s\n", c.Path, oneLine(c.Before), oneLine(c.After))
+	}
+	verb := "updated"
+	if opts.dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("%s %d of %d items\n", verb, len(changes), len(ids))
+	return 0
+}
+
+// oneLine collapses newlines so a caption fits on one output line
+func oneLine(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func cliAdd(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	tag := fs.String("tag", "", "tag to add")
+	position := fs.String("position", "append", "where to add the tag: prepend or append")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *tag == "" || (*position != "prepend" && *position != "append") {
+		fs.Usage()
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	return cliRunBatch(app, opts, func(ids []string) error {
+		return app.BatchAddTag(ids, *tag, *position)
+	})
+}
+
+func cliRemove(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	tag := fs.String("tag", "", "tag (or pattern with -regex) to remove")
+	useRegex := fs.Bool("regex", false, "treat -tag as a regular expression")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *tag == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	return cliRunBatch(app, opts, func(ids []string) error {
+		return app.BatchRemoveTag(ids, *tag, *useRegex)
+	})
+}
+
+func cliReplace(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	oldTag := fs.String("old", "", "tag (or pattern with -regex) to replace")
+	newTag := fs.String("new", "", "replacement tag")
+	useRegex := fs.Bool("regex", false, "treat -old as a regular expression")
+	weight := fs.String("weight", WeightKeep, "emphasis of replaced tags such as (tag:1.2): keep, explicit or strip")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *oldTag == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	return cliRunBatch(app, opts, func(ids []string) error {
+		return app.BatchReplaceTag(ids, *oldTag, *newTag, *useRegex, *weight)
+	})
+}
+
+func cliNormalize(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	steps := fs.String("steps", "", "comma-separated steps overriding the dataset's pipeline: "+strings.Join(normalizeSteps, ","))
+	underscores := fs.String("underscores", "", "direction of the underscores step: space or underscore")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	// 命令行参数只影响本次运行，不写回数据集配置
+	cfg := app.config.Normalize
+	if *steps != "" {
+		cfg.Steps = strings.Split(*steps, ",")
+	}
+	if *underscores != "" {
+		cfg.Underscores = *underscores
+	}
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	app.config.Normalize = cfg
+	return cliRunBatch(app, opts, app.BatchNormalizeTags)
+}
+
+func cliReorder(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	var reorder ReorderOptions
+	fs.StringVar(&reorder.Strategy, "by", "", "sort strategy: "+strings.Join(reorderStrategies, ", "))
+	priority := fs.String("priority", "", "comma-separated tags put first by -by priority")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *priority != "" {
+		reorder.Priority = strings.Split(*priority, ",")
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	return cliRunBatch(app, opts, func(ids []string) error {
+		return app.BatchReorderTags(ids, reorder)
+	})
+}
+
+func cliRules(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	fix := fs.Bool("fix", false, "replace aliases and add missing implied tags")
+	importCSV := fs.String("import", "", "merge aliases or implications from a Danbooru-style CSV first")
+	kind := fs.String("kind", TagRuleAliases, "what -import contains: aliases or implications")
+	global := fs.Bool("global", false, "import into the global rules shared by all datasets")
+	spaces := fs.Bool("spaces", false, "convert underscores in imported tags to spaces")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	if *importCSV != "" {
+		scope := TagRulesDataset
+		if *global {
+			scope = TagRulesGlobal
+		}
+		if err := app.SetArchiveSaveMode(opts.archiveSave); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		n, err := app.ImportTagRulesCSV(*importCSV, *kind, scope, *spaces)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "imported %d %s into the %s rules\n", n, *kind, scope)
+	}
+	if *fix {
+		return cliRunBatch(app, opts, app.BatchApplyTagRules)
+	}
+
+	ids, err := cliTargetIDs(app, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	issues := app.GetTagRuleIssues(ids)
+	code := 0
+	if len(issues) > 0 {
+		code = 1
+	}
+	if opts.json {
+		writeJSON(os.Stdout, issues)
+		return code
+	}
+
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "KIND\tTAG\tSUGGESTION\tPATH")
+	for _, issue := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", issue.Kind, issue.Tag, issue.Suggestion, issue.Path)
+	}
+	tw.Flush()
+	fmt.Printf("%d items checked, %d problems\n", len(ids), len(issues))
+	return code
+}
+
+func cliKeep(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	fix := fs.Bool("fix", false, "move the keep tokens to the front and add the missing ones")
+	tokens := fs.String("tokens", "", "comma-separated keep tokens to use instead of the dataset's")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	if *tokens != "" {
+		// 只影响这次检查，不写入数据集配置
+		cfg := KeepTokensConfig{Tokens: strings.Split(*tokens, ",")}
+		cfg.normalize()
+		if err := cfg.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		app.config.KeepTokens = cfg
+	}
+	if *fix {
+		return cliRunBatch(app, opts, app.BatchApplyKeepTokens)
+	}
+
+	ids, err := cliTargetIDs(app, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	issues := app.GetKeepTokenIssues(ids)
+	code := 0
+	if len(issues) > 0 {
+		code = 1
+	}
+	if opts.json {
+		writeJSON(os.Stdout, issues)
+		return code
+	}
+
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "KIND\tEXPECTED\tLEADING\tPATH")
+	for _, issue := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", issue.Kind, strings.Join(issue.Expected, ", "), strings.Join(issue.Leading, ", "), issue.Path)
+	}
+	tw.Flush()
+	fmt.Printf("%d items checked, %d problems\n", len(ids), len(issues))
+	return code
+}
+
+func cliValidate(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	result, ok := cliLoad(app, folder)
+	if !ok {
+		return 1
+	}
+
+	code := 0
+	if len(result.Issues) > 0 {
+		code = 1
+	}
+	if opts.json {
+		writeJSON(os.Stdout, result.Issues)
+		return code
+	}
+
+	printIssues(result.Issues)
+	fmt.Printf("%d items checked, %d problems\n", result.TotalItems, len(result.Issues))
+	return code
+}
+
+// printIssues prints one issue per line, with collision losers indented below
+func printIssues(issues []ScanIssue) {
+	tw := newTable(os.Stdout)
+	for _, issue := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", issue.Kind, issue.Path, issue.Message)
+		for _, related := range issue.Related {
+			fmt.Fprintf(tw, "\t  %s\t\n", related)
+		}
+	}
+	tw.Flush()
+}
+
+func cliFix(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	kind := fs.String("kind", "", "issue kind to resolve (see validate)")
+	action := fs.String("action", "", "resolution: delete, rename, create_caption or ignore")
+	var archiveSave string
+	registerArchiveSave(fs, &archiveSave)
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if _, known := issueActions[*kind]; !known || *action == "" {
+		fs.Usage()
+		fmt.Fprintln(os.Stderr, "\nactions per kind:")
+		for _, k := range []string{IssueCollision, IssueOrphanCaption, IssueMissingCaption, IssueEmptyCaption, IssueUnreadable} {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", k, strings.Join(issueActions[k], ", "))
+		}
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	if err := app.SetArchiveSaveMode(archiveSave); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	targeted := 0
+	for _, issue := range app.GetScanIssues() {
+		if issue.Kind == *kind {
+			targeted++
+		}
+	}
+	remaining, err := app.ResolveIssues(*kind, *action)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if opts.json {
+		return writeJSON(os.Stdout, remaining)
+	}
+
+	printIssues(remaining)
+	fmt.Printf("applied %s to %d %s issues, %d issues remaining\n", *action, targeted, *kind, len(remaining))
+	return 0
+}
+
+func cliConcepts(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliBatchOptions
+	opts.register(fs)
+	trigger := fs.String("add-trigger", "", "add each concept name as a tag to its items: prepend or append")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *trigger != "" && *trigger != "prepend" && *trigger != "append" {
+		fs.Usage()
+		return 2
+	}
+
+	result, ok := cliLoad(app, folder)
+	if !ok {
+		return 1
+	}
+
+	if *trigger != "" {
+		return cliRunBatch(app, opts, func([]string) error {
+			for _, c := range result.Concepts {
+				if _, err := app.BatchAddConceptTag(c.Dir, *trigger); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if opts.json {
+		return writeJSON(os.Stdout, result.Concepts)
+	}
+
+	total := 0
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "CONCEPT\tREPEATS\tITEMS\tSAMPLES/EPOCH\tDIR")
+	for _, c := range result.Concepts {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", c.Concept, c.Repeats, c.Items, c.SamplesPerEpoch, c.Dir)
+		total += c.SamplesPerEpoch
+	}
+	tw.Flush()
+	fmt.Printf("\n%d samples per epoch\n", total)
+	return 0
+}
+
+func cliCooccur(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	filter := fs.String("filter", "", "only count items whose caption contains this phrase")
+	where := fs.String("where", "", `only count items matching conditions, e.g. "width<1024 format=png"`)
+	top := fs.Int("top", 30, "relate the N most frequent tags, or the N most frequent neighbours of -tag")
+	tag := fs.String("tag", "", "list the tags found together with this tag instead")
+	minCount := fs.Int("min", 1, "skip pairs found together in fewer than N captions")
+	sortBy := fs.String("sort", CooccurSortCount, "sort pairs by "+strings.Join(cooccurSorts, ", "))
+	csvOut := fs.Bool("csv", false, "print the pairs as CSV")
+	graphOut := fs.Bool("graphml", false, "print the tags and pairs as a GraphML graph")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	ids, err := cliTargetIDs(app, cliBatchOptions{filter: *filter, where: *where})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	c, err := app.GetTagCooccurrence(ids, CooccurrenceOptions{Top: *top, Tag: *tag, MinCount: *minCount, Sort: *sortBy})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	write := func(write func(io.Writer, TagCooccurrence) error) int {
+		if err := write(os.Stdout, c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case opts.json:
+		return writeJSON(os.Stdout, c)
+	case *csvOut:
+		return write(writeCooccurrenceCSV)
+	case *graphOut:
+		return write(writeCooccurrenceGraphML)
+	}
+
+	tw := newTable(os.Stdout)
+	fmt.Fprintln(tw, "COUNT\tLIFT\tPMI\tP(B|A)\tP(A|B)\tA\tB")
+	for _, p := range c.Pairs {
+		fmt.Fprintf(tw, "%d\t%.2f\t%.2f\t%.1f%%\t%.1f%%\t%s\t%s\n", p.Count, p.Lift, p.PMI, p.BGivenA*100, p.AGivenB*100, p.A, p.B)
+	}
+	tw.Flush()
+	fmt.Printf("\n%d pairs among %d tags in %d items\n", len(c.Pairs), len(c.Tags), c.Items)
+	return 0
+}
+
+// cliDirItems returns the items of app whose media is under dir, relative to the
+// dataset root
+func cliDirItems(app *App, items []DatasetItem, dir string) []DatasetItem {
+	root := filepath.Join(app.datasetPath, dir)
+	result := make([]DatasetItem, 0)
+	for _, item := range items {
+		if rel, err := filepath.Rel(root, item.MediaPath); err == nil && !strings.HasPrefix(rel, "..") {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func cliCompare(app *App, fs *flag.FlagSet, args []string) int {
+	var opts cliOptions
+	opts.register(fs)
+	filter := fs.String("filter", "", "target items whose caption contains this phrase")
+	where := fs.String("where", "", `target items matching conditions, e.g. "width<1024 format=png"`)
+	dir := fs.String("dir", "", "target items under this subfolder of the dataset")
+	baselineDir := fs.String("baseline-dir", "", "compare with the items under this subfolder instead of all other items")
+	baselineFolder := fs.String("baseline", "", "compare with the items of another dataset folder instead")
+	unit := fs.String("unit", CompareUnitPhrases, "compare "+strings.Join(compareUnits, " or "))
+	metric := fs.String("metric", CompareMetricLLR, "rank by "+strings.Join(compareMetrics, " or "))
+	minDocs := fs.Int("min-docs", 2, "only list phrases found in at least N captions of the set they are more common in")
+	limit := fs.Int("limit", 30, "list at most N phrases each way")
+	folder, ok := parseCLIFolder(fs, args)
+	if !ok {
+		return 2
+	}
+	if *filter == "" && *where == "" && *dir == "" {
+		fmt.Fprintln(os.Stderr, "select the target items with -filter, -where or -dir")
+		return 2
+	}
+	if *baselineDir != "" && *baselineFolder != "" {
+		fmt.Fprintln(os.Stderr, "-baseline-dir and -baseline cannot be combined")
+		return 2
+	}
+
+	if _, ok := cliLoad(app, folder); !ok {
+		return 1
+	}
+	target, err := app.FilterItems(*filter, *where)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *dir != "" {
+		target = cliDirItems(app, target, *dir)
+	}
+	ids := make([]string, 0, len(target))
+	for _, item := range target {
+		ids = append(ids, item.ID)
+	}
+
+	var baseline []DatasetItem
+	switch {
+	case *baselineDir != "":
+		baseline = cliDirItems(app, app.items, *baselineDir)
+	case *baselineFolder != "":
+		other := NewApp()
+		if _, ok := cliLoad(other, *baselineFolder); !ok {
+			return 1
+		}
+		baseline = other.items
+	default:
+		baseline = app.itemsByIDs(ids, true)
+	}
+
+	c, err := app.comparePhrases(target, baseline, CompareOptions{Unit: *unit, Metric: *metric, MinDocs: *minDocs, Limit: *limit})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if opts.json {
+		return writeJSON(os.Stdout, c)
+	}
+
+	fmt.Printf("%d target items, %d baseline items\n", c.TargetItems, c.BaselineItems)
+	for _, list := range []struct {
+		title string
+		diffs []PhraseDifference
+	}{{"over-represented in the target", c.Over}, {"under-represented in the target", c.Under}} {
+		fmt.Printf("\n%s:\n", list.title)
+		tw := newTable(os.Stdout)
+		fmt.Fprintln(tw, "LLR\tTFIDF\tTARGET\tBASELINE\tPHRASE")
+		for _, d := range list.diffs {
+			fmt.Fprintf(tw, "%.2f\t%.3f\t%d (%.1f%%)\t%d (%.1f%%)\t%s\n", d.LLR, d.TFIDF, d.TargetDocs, d.TargetShare*100, d.BaselineDocs, d.BaselineShare*100, d.Phrase)
+		}
+		tw.Flush()
+	}
+	return 0
+}
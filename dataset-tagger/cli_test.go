@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestParseCLIFolderAcceptsFlagsAfterFolder(t *testing.T) {
+	cases := []struct {
+		args   []string
+		folder string
+		ok     bool
+	}{
+		{[]string{"-json", "-kind", "empty_caption", "data"}, "data", true},
+		{[]string{"data", "-json", "-kind", "empty_caption"}, "data", true},
+		{[]string{"-json", "data", "-kind", "empty_caption"}, "data", true},
+		{[]string{"-json"}, "", false},
+		{[]string{"data", "other"}, "", false},
+		{[]string{"data", "-json", "other"}, "", false},
+		{[]string{"data", "-unknown"}, "", false},
+	}
+	for _, c := range cases {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var opts cliOptions
+		opts.register(fs)
+		kind := fs.String("kind", "", "")
+
+		folder, ok := parseCLIFolder(fs, c.args)
+		if folder != c.folder || ok != c.ok {
+			t.Errorf("parseCLIFolder(%q) = %q, %v; want %q, %v", c.args, folder, ok, c.folder, c.ok)
+			continue
+		}
+		if ok && (!opts.json || *kind != "empty_caption") {
+			t.Errorf("parseCLIFolder(%q) parsed json=%v kind=%q", c.args, opts.json, *kind)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Units compared by ComparePhrases
+const (
+	// CompareUnitPhrases compares the phrases of the common phrase statistics,
+	// counted with the dataset's phrase settings
+	CompareUnitPhrases = "phrases"
+	// CompareUnitTags compares exact comma-separated tags
+	CompareUnitTags = "tags"
+)
+
+// Metrics ranking the differences
+const (
+	// CompareMetricLLR ranks by Dunning's log-likelihood ratio (G²), which does
+	// not overrate phrases seen in only a few captions
+	CompareMetricLLR = "llr"
+	// CompareMetricTFIDF ranks by the difference of the caption shares weighted by
+	// the inverse document frequency over both sets
+	CompareMetricTFIDF = "tfidf"
+)
+
+var (
+	compareUnits   = []string{CompareUnitPhrases, CompareUnitTags}
+	compareMetrics = []string{CompareMetricLLR, CompareMetricTFIDF}
+)
+
+// CompareOptions selects what ComparePhrases counts and how it ranks
+type CompareOptions struct {
+	// Unit is phrases or tags; default phrases
+	Unit string `json:"unit"`
+	// Metric is llr or tfidf; default llr
+	Metric string `json:"metric"`
+	// MinDocs is the number of captions of the set a phrase is more common in it
+	// must occur in; default 2
+	MinDocs int `json:"minDocs"`
+	// Limit is the number of phrases in each list; default 50
+	Limit int `json:"limit"`
+}
+
+func (o *CompareOptions) normalize() {
+	if o.Unit == "" {
+		o.Unit = CompareUnitPhrases
+	}
+	if o.Metric == "" {
+		o.Metric = CompareMetricLLR
+	}
+	if o.MinDocs <= 0 {
+		o.MinDocs = 2
+	}
+	if o.Limit <= 0 {
+		o.Limit = 50
+	}
+}
+
+func (o *CompareOptions) validate() error {
+	if !containsString(compareUnits, o.Unit) {
+		return fmt.Errorf("unknown compare unit: %s", o.Unit)
+	}
+	if !containsString(compareMetrics, o.Metric) {
+		return fmt.Errorf("unknown compare metric: %s", o.Metric)
+	}
+	return nil
+}
+
+// PhraseDifference is a phrase more or less common in the target set than in
+// the baseline. Docs count captions and Share is Docs over the set size; TFIDF
+// is positive when the phrase is more common in the target.
+type PhraseDifference struct {
+	Phrase        string  `json:"phrase"`
+	Category      string  `json:"category,omitempty"`
+	TargetDocs    int     `json:"targetDocs"`
+	BaselineDocs  int     `json:"baselineDocs"`
+	TargetShare   float64 `json:"targetShare"`
+	BaselineShare float64 `json:"baselineShare"`
+	LLR           float64 `json:"llr"`
+	TFIDF         float64 `json:"tfidf"`
+}
+
+// PhraseComparison is the result of ComparePhrases: the phrases over-represented
+// in the target set and those under-represented or missing there, each ranked
+// by the chosen metric
+type PhraseComparison struct {
+	TargetItems   int                `json:"targetItems"`
+	BaselineItems int                `json:"baselineItems"`
+	Over          []PhraseDifference `json:"over"`
+	Under         []PhraseDifference `json:"under"`
+}
+
+// compareGram is a phrase counted in both sets
+type compareGram struct {
+	// words are the words of a word phrase, or the text of a rune phrase
+	words    []string
+	runes    bool
+	target   int
+	baseline int
+}
+
+func compareKey(runes bool, words []string) string {
+	kind := "w"
+	if runes {
+		kind = "c"
+	}
+	return kind + strings.Join(words, "\x1f")
+}
+
+func (g *compareGram) text() string {
+	if g.runes {
+		return strings.Join(g.words, "")
+	}
+	return joinPhraseWords(g.words)
+}
+
+// captionGrams returns the distinct word phrases of a caption keyed by
+// compareKey, cut like the common phrase statistics; the caller must hold a.mu
+func (a *App) captionGrams(text string, cfg PhraseConfig) map[string][]string {
+	grams := make(map[string][]string)
+	for _, run := range phraseWords(text, a.segmenter) {
+		for i := range run {
+			for n := cfg.MinWords; n <= cfg.MaxWords && i+n <= len(run); n++ {
+				words := run[i : i+n]
+				if stopwordBounded(words, a.phraseFilter) || a.phraseFilter.blocked[joinPhraseWords(words)] {
+					continue
+				}
+				grams[compareKey(false, words)] = words
+			}
+		}
+	}
+	return grams
+}
+
+// runeGrams counts the rune phrases of the target and baseline captions
+// through one phrase index over both, so no caption is cut into all of its
+// substrings. Like the common phrase statistics, a phrase found in exactly the
+// same captions of both sets as a longer phrase containing it is left out.
+func runeGrams(target, baseline []string, cfg PhraseConfig) []*compareGram {
+	x := newPhraseIndex(cfg.MaxChars)
+	for i, text := range target {
+		x.add("t"+strconv.Itoa(i), text)
+	}
+	for i, text := range baseline {
+		x.add("b"+strconv.Itoa(i), text)
+	}
+	inTarget := x.setCounts(target)
+	keep := x.maximal(func(s, t int32) bool {
+		return x.states[s].docs == x.states[t].docs && inTarget[s] == inTarget[t]
+	})
+	grams := make([]*compareGram, 0)
+	for s := int32(1); s < int32(len(x.states)); s++ {
+		n := x.phraseLen(s)
+		if !keep[s] || n < int32(cfg.MinChars) {
+			continue
+		}
+		grams = append(grams, &compareGram{
+			words:    []string{x.text(s, n)},
+			runes:    true,
+			target:   int(inTarget[s]),
+			baseline: int(x.states[s].docs - inTarget[s]),
+		})
+	}
+	return grams
+}
+
+// dropContained drops word phrases found in exactly the same captions of both
+// sets as a longer phrase containing them, as the common phrase statistics do.
+// Every shorter part is checked, since parts bounded by stopwords are not
+// counted.
+func dropContained(grams map[string]*compareGram) {
+	dropped := make(map[string]bool)
+	for _, g := range grams {
+		n := len(g.words)
+		for i := 0; i < n; i++ {
+			for j := i + 1; j <= n; j++ {
+				if j-i == n {
+					continue
+				}
+				key := compareKey(g.runes, g.words[i:j])
+				if sub, ok := grams[key]; ok && sub.target == g.target && sub.baseline == g.baseline {
+					dropped[key] = true
+				}
+			}
+		}
+	}
+	for key := range dropped {
+		delete(grams, key)
+	}
+}
+
+// xlogx is x·ln(x), 0 for 0
+func xlogx(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return x * math.Log(x)
+}
+
+// logLikelihoodRatio is Dunning's G² for a phrase in a of na target captions and
+// b of nb baseline captions
+func logLikelihoodRatio(a, na, b, nb int) float64 {
+	n := float64(na + nb)
+	cells := xlogx(float64(a)) + xlogx(float64(na-a)) + xlogx(float64(b)) + xlogx(float64(nb-b))
+	rows := xlogx(float64(na)) + xlogx(float64(nb))
+	cols := xlogx(float64(a+b)) + xlogx(n-float64(a+b))
+	return math.Max(0, 2*(cells-rows-cols+xlogx(n)))
+}
+
+// comparePhrases ranks the phrases that tell target apart from baseline; the
+// caller must hold a.mu
+func (a *App) comparePhrases(target, baseline []DatasetItem, opts CompareOptions) (PhraseComparison, error) {
+	opts.normalize()
+	if err := opts.validate(); err != nil {
+		return PhraseComparison{}, err
+	}
+	if len(target) == 0 || len(baseline) == 0 {
+		return PhraseComparison{}, fmt.Errorf("both sets need at least one item to compare")
+	}
+
+	cfg := a.phraseConfig
+	grams := make(map[string]*compareGram)
+	// 按字统计的标注交给短语索引，不在这里展开所有子串
+	runeTexts := [2][]string{}
+	count := func(items []DatasetItem, inTarget bool) {
+		for _, item := range items {
+			var found map[string][]string
+			switch {
+			case opts.Unit == CompareUnitTags:
+				found = make(map[string][]string, len(item.Tags))
+				for _, tag := range item.Tags {
+					found[compareKey(false, []string{tag})] = []string{tag}
+				}
+			case countsByRunes(cfg.Mode, item.RawTags):
+				set := 1
+				if inTarget {
+					set = 0
+				}
+				runeTexts[set] = append(runeTexts[set], item.RawTags)
+				continue
+			default:
+				found = a.captionGrams(item.RawTags, cfg)
+			}
+			for key, words := range found {
+				g := grams[key]
+				if g == nil {
+					g = &compareGram{words: words, runes: key[0] == 'c'}
+					grams[key] = g
+				}
+				if inTarget {
+					g.target++
+				} else {
+					g.baseline++
+				}
+			}
+		}
+	}
+	count(target, true)
+	count(baseline, false)
+	if opts.Unit == CompareUnitPhrases {
+		dropContained(grams)
+		for _, g := range runeGrams(runeTexts[0], runeTexts[1], cfg) {
+			grams[compareKey(true, g.words)] = g
+		}
+	}
+
+	na, nb := len(target), len(baseline)
+	n := float64(na + nb)
+	// 不同切分可能写出同一个短语，保留差异更大的
+	spelled := make(map[string]PhraseDifference)
+	for _, g := range grams {
+		text := g.text()
+		if g.runes && !a.phraseFilter.allows(text) {
+			continue
+		}
+		d := PhraseDifference{
+			Phrase:        text,
+			TargetDocs:    g.target,
+			BaselineDocs:  g.baseline,
+			TargetShare:   float64(g.target) / float64(na),
+			BaselineShare: float64(g.baseline) / float64(nb),
+			LLR:           logLikelihoodRatio(g.target, na, g.baseline, nb),
+		}
+		d.TFIDF = (d.TargetShare - d.BaselineShare) * math.Log(n/float64(g.target+g.baseline))
+		if old, ok := spelled[text]; !ok || d.LLR > old.LLR {
+			spelled[text] = d
+		}
+	}
+
+	result := PhraseComparison{TargetItems: na, BaselineItems: nb, Over: make([]PhraseDifference, 0), Under: make([]PhraseDifference, 0)}
+	for _, d := range spelled {
+		switch {
+		case d.TargetShare > d.BaselineShare && d.TargetDocs >= opts.MinDocs:
+			result.Over = append(result.Over, d)
+		case d.TargetShare < d.BaselineShare && d.BaselineDocs >= opts.MinDocs:
+			result.Under = append(result.Under, d)
+		}
+	}
+	for _, list := range []*[]PhraseDifference{&result.Over, &result.Under} {
+		sortPhraseDifferences(*list, opts.Metric)
+		if len(*list) > opts.Limit {
+			*list = (*list)[:opts.Limit]
+		}
+		a.categorizeDifferences(*list)
+	}
+	return result, nil
+}
+
+// sortPhraseDifferences orders diffs by the metric, largest difference first;
+// ties fall back to the caption counts, then the phrase
+func sortPhraseDifferences(diffs []PhraseDifference, metric string) {
+	score := func(d PhraseDifference) float64 {
+		if metric == CompareMetricTFIDF {
+			return math.Abs(d.TFIDF)
+		}
+		return d.LLR
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if si, sj := score(diffs[i]), score(diffs[j]); si != sj {
+			return si > sj
+		}
+		if ti, tj := diffs[i].TargetDocs+diffs[i].BaselineDocs, diffs[j].TargetDocs+diffs[j].BaselineDocs; ti != tj {
+			return ti > tj
+		}
+		return diffs[i].Phrase < diffs[j].Phrase
+	})
+}
+
+// categorizeDifferences fills in the dictionary categories of diffs
+func (a *App) categorizeDifferences(diffs []PhraseDifference) {
+	tags := make([]TagInfo, len(diffs))
+	for i, d := range diffs {
+		tags[i].Tag = d.Phrase
+	}
+	a.categorizeTags(tags)
+	for i := range diffs {
+		diffs[i].Category = tags[i].Category
+	}
+}
+
+// itemsByIDs returns the items with ids in dataset order, or with exclude set
+// the items without them; the caller must hold a.mu
+func (a *App) itemsByIDs(ids []string, exclude bool) []DatasetItem {
+	wanted := toSet(ids)
+	items := make([]DatasetItem, 0, len(ids))
+	for _, item := range a.items {
+		if wanted[item.ID] != exclude {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ComparePhrases finds what sets the items with targetIDs apart from the items
+// with baselineIDs: the phrases or tags over-represented in the target, and
+// those under-represented or missing there. With no baselineIDs the target is
+// compared with the rest of the dataset, such as the items of a tag filter
+// with all the others; two concept folders are compared by passing the items of
+// each.
+func (a *App) ComparePhrases(targetIDs []string, baselineIDs []string, opts CompareOptions) (PhraseComparison, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	target := a.itemsByIDs(targetIDs, false)
+	baseline := a.itemsByIDs(targetIDs, true)
+	if len(baselineIDs) > 0 {
+		baseline = a.itemsByIDs(baselineIDs, false)
+	}
+	return a.comparePhrases(target, baseline, opts)
+}
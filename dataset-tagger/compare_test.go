@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bruteRuneGrams counts every substring of minLen to maxLen runes in each set
+// and drops those a phrase one rune longer with the same counts contains
+func bruteRuneGrams(target, baseline []string, minLen, maxLen int) map[string][2]int {
+	counts := make(map[string][2]int)
+	for set, texts := range [][]string{target, baseline} {
+		for _, text := range texts {
+			seen := make(map[string]bool)
+			for _, seg := range phraseSegments(text) {
+				for i := range seg {
+					for n := minLen; n <= maxLen && i+n <= len(seg); n++ {
+						seen[string(seg[i:i+n])] = true
+					}
+				}
+			}
+			for sub := range seen {
+				c := counts[sub]
+				c[set]++
+				counts[sub] = c
+			}
+		}
+	}
+	kept := make(map[string][2]int)
+	for text, c := range counts {
+		covered := false
+		for longer, lc := range counts {
+			if lc == c && len([]rune(longer)) == len([]rune(text))+1 && strings.Contains(longer, text) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept[text] = c
+		}
+	}
+	return kept
+}
+
+func TestRuneGramsMatchBruteForce(t *testing.T) {
+	target := []string{"红色帽子的女孩，站在海边", "红色帽子，海边", "女孩在海边"}
+	baseline := []string{"蓝色帽子的女孩", "海边的男孩，红色", "", "帽子帽子"}
+	for _, tt := range []struct{ minChars, maxChars int }{{2, 15}, {2, 3}, {1, 4}} {
+		cfg := defaultPhraseConfig()
+		cfg.MinChars, cfg.MaxChars = tt.minChars, tt.maxChars
+		got := make(map[string][2]int)
+		for _, g := range runeGrams(target, baseline, cfg) {
+			got[g.text()] = [2]int{g.target, g.baseline}
+		}
+		if want := bruteRuneGrams(target, baseline, tt.minChars, tt.maxChars); !reflect.DeepEqual(got, want) {
+			t.Errorf("%d-%d chars:\n got %v\nwant %v", tt.minChars, tt.maxChars, got, want)
+		}
+	}
+}
+
+func TestDropContained(t *testing.T) {
+	gram := func(target, baseline int, words ...string) *compareGram {
+		return &compareGram{words: words, target: target, baseline: baseline}
+	}
+	grams := make(map[string]*compareGram)
+	for _, g := range []*compareGram{
+		gram(2, 0, "blue", "sky"),
+		gram(2, 0, "blue"),
+		gram(3, 0, "sky"),
+		// with 是停用词，"with hat" 不计数，"girl" 仍被三词短语覆盖
+		gram(2, 1, "girl", "with", "hat"),
+		gram(2, 1, "girl"),
+		gram(2, 1, "hat"),
+		gram(2, 2, "red"),
+	} {
+		grams[compareKey(false, g.words)] = g
+	}
+	dropContained(grams)
+	got := make([]string, 0, len(grams))
+	for _, g := range grams {
+		got = append(got, g.text())
+	}
+	sort.Strings(got)
+	if want := []string{"blue sky", "girl with hat", "red", "sky"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kept %q, want %q", got, want)
+	}
+}
+
+func TestLogLikelihoodRatio(t *testing.T) {
+	// 2·Σ O·ln(O/E) of the table [[1 1] [0 2]]
+	want := 2 * (math.Log(1/0.5) + math.Log(1/1.5) + 2*math.Log(2/1.5))
+	if got := logLikelihoodRatio(1, 2, 0, 2); math.Abs(got-want) > 1e-9 {
+		t.Errorf("G² = %v, want %v", got, want)
+	}
+	if got := logLikelihoodRatio(5, 10, 10, 20); got != 0 {
+		t.Errorf("G² of equal shares = %v, want 0", got)
+	}
+	if a, b := logLikelihoodRatio(4, 10, 1, 20), logLikelihoodRatio(1, 20, 4, 10); math.Abs(a-b) > 1e-9 {
+		t.Errorf("G² not symmetric: %v, %v", a, b)
+	}
+}
+
+func TestComparePhrasesRanking(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"t1.png": "", "t1.txt": "broad, rare, common",
+		"t2.png": "", "t2.txt": "broad, common",
+		"t3.png": "", "t3.txt": "broad, common",
+		"t4.png": "", "t4.txt": "broad, common",
+		"b1.png": "", "b1.txt": "broad, blue, common",
+		"b2.png": "", "b2.txt": "broad, blue, common",
+		"b3.png": "", "b3.txt": "blue, common",
+		"b4.png": "", "b4.txt": "common",
+	})
+	app := scanTestDataset(t, root)
+	ids := func(names ...string) []string {
+		out := make([]string, len(names))
+		for i, name := range names {
+			out[i] = testItem(t, app, name).ID
+		}
+		return out
+	}
+	target, baseline := ids("t1.png", "t2.png", "t3.png", "t4.png"), ids("b1.png", "b2.png", "b3.png", "b4.png")
+
+	// broad 是 4/4 对 2/4，rare 是 1/4 对 0/4：对数似然比看重 broad 的样本量，
+	// TF-IDF 看重 rare 的稀有程度
+	for _, tt := range []struct {
+		metric string
+		over   []string
+	}{
+		{CompareMetricLLR, []string{"broad", "rare"}},
+		{CompareMetricTFIDF, []string{"rare", "broad"}},
+	} {
+		result, err := app.ComparePhrases(target, baseline, CompareOptions{Unit: CompareUnitTags, Metric: tt.metric, MinDocs: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		over := make([]string, len(result.Over))
+		for i, d := range result.Over {
+			over[i] = d.Phrase
+		}
+		if !reflect.DeepEqual(over, tt.over) {
+			t.Errorf("%s: over = %q, want %q", tt.metric, over, tt.over)
+		}
+		if len(result.Under) != 1 || result.Under[0].Phrase != "blue" || result.Under[0].BaselineDocs != 3 {
+			t.Errorf("%s: under = %+v, want blue in 3 baseline captions", tt.metric, result.Under)
+		}
+	}
+
+	result, err := app.ComparePhrases(target, baseline, CompareOptions{Unit: CompareUnitTags, MinDocs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Over) != 1 || result.Over[0].Phrase != "broad" {
+		t.Errorf("minDocs 2: over = %+v, want only broad", result.Over)
+	}
+}
+
+func TestComparePhrasesByRunes(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"t1.png": "", "t1.txt": "红色帽子的女孩",
+		"t2.png": "", "t2.txt": "戴着红色帽子",
+		"b1.png": "", "b1.txt": "蓝色的女孩",
+		"b2.png": "", "b2.txt": "蓝色外套的女孩",
+	})
+	app := scanTestDataset(t, root)
+	app.phraseConfig.Mode = PhraseModeChars
+	result, err := app.ComparePhrases([]string{testItem(t, app, "t1.png").ID, testItem(t, app, "t2.png").ID}, nil, CompareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Over) != 1 || result.Over[0].Phrase != "红色帽子" || result.Over[0].TargetDocs != 2 {
+		t.Fatalf("over = %+v, want only 红色帽子 in both target captions", result.Over)
+	}
+	under := make([]string, len(result.Under))
+	for i, d := range result.Under {
+		under[i] = d.Phrase
+	}
+	if want := []string{"蓝色", "的女孩"}; !reflect.DeepEqual(under, want) {
+		t.Fatalf("under = %q, want %q", under, want)
+	}
+}
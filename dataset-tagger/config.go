@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// datasetConfigFile lives in the dataset root, so it travels with the dataset
+// and every teammate opening the folder gets the same settings
+const datasetConfigFile = ".dataset-tagger.json"
+
+// DatasetConfig holds the per-dataset settings
+type DatasetConfig struct {
+	Pairing    PairingConfig    `json:"pairing"`
+	Walk       WalkConfig       `json:"walk"`
+	Caption    CaptionConfig    `json:"caption"`
+	Normalize  NormalizeConfig  `json:"normalize"`
+	Dictionary DictionaryConfig `json:"dictionary"`
+	KeepTokens KeepTokensConfig `json:"keepTokens"`
+	Segment    SegmentConfig    `json:"segment"`
+	Phrases    PhraseConfig     `json:"phrases"`
+}
+
+// defaultDatasetConfig reproduces the behaviour before settings existed
+func defaultDatasetConfig() DatasetConfig {
+	return DatasetConfig{
+		Pairing:    defaultPairingConfig(),
+		Walk:       defaultWalkConfig(),
+		Caption:    defaultCaptionConfig(),
+		Normalize:  defaultNormalizeConfig(),
+		Dictionary: defaultDictionaryConfig(),
+		KeepTokens: defaultKeepTokensConfig(),
+		Segment:    defaultSegmentConfig(),
+		Phrases:    defaultPhraseConfig(),
+	}
+}
+
+// loadDatasetConfig reads the config from root; a missing file yields the defaults
+func loadDatasetConfig(src datasetSource, root string) (DatasetConfig, error) {
+	data, err := src.ReadFile(filepath.Join(root, datasetConfigFile))
+	if os.IsNotExist(err) {
+		return defaultDatasetConfig(), nil
+	}
+	if err != nil {
+		return defaultDatasetConfig(), err
+	}
+	// 未写出的字段由 normalize 补全默认值；短语设置整段缺失时 MaxDocRatio 也取默认
+	cfg := DatasetConfig{Phrases: PhraseConfig{MaxDocRatio: 1}}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return defaultDatasetConfig(), fmt.Errorf("%s: %v", datasetConfigFile, err)
+	}
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		return defaultDatasetConfig(), fmt.Errorf("%s: %v", datasetConfigFile, err)
+	}
+	return cfg, nil
+}
+
+// normalize fills defaults for every section
+func (c *DatasetConfig) normalize() {
+	c.Pairing.normalize()
+	c.Walk.normalize()
+	c.Caption.normalize()
+	c.Normalize.normalize()
+	c.Dictionary.normalize()
+	c.KeepTokens.normalize()
+	c.Segment.normalize()
+	c.Phrases.normalize()
+}
+
+// validate checks every section
+func (c *DatasetConfig) validate() error {
+	if err := c.Pairing.validate(); err != nil {
+		return err
+	}
+	if err := c.Caption.validate(); err != nil {
+		return err
+	}
+	if err := c.Normalize.validate(); err != nil {
+		return err
+	}
+	if err := c.Dictionary.validate(); err != nil {
+		return err
+	}
+	if err := c.KeepTokens.validate(); err != nil {
+		return err
+	}
+	if err := c.Segment.validate(); err != nil {
+		return err
+	}
+	return c.Phrases.validate()
+}
+
+// files lists the files cfg points to, resolved against root
+func (c DatasetConfig) files(root string) []string {
+	return append(c.Phrases.files(root), configFilePath(root, c.Segment.UserDict))
+}
+
+// configFiles lists the files of the dataset config and of the phrase options
+// in use; the caller must hold a.mu
+func (a *App) configFiles() []string {
+	return append(a.config.files(a.datasetPath), a.phraseConfig.files(a.datasetPath)...)
+}
+
+// ignoreConfigFiles keeps the pairer away from configFiles, so a stopword list
+// is not taken for an orphan caption. It reports whether they changed, in which
+// case a running watcher has to be restarted; the caller must hold a.mu.
+func (a *App) ignoreConfigFiles() bool {
+	p := a.pairing.ignoring(a.configFiles())
+	if maps.Equal(p.ignored, a.pairing.ignored) {
+		return false
+	}
+	a.pairing = p
+	// 之前当作孤立标注报告的配置文件不再是问题，以免被一并删除
+	for i := len(a.issues) - 1; i >= 0; i-- {
+		if a.issues[i].Kind == IssueOrphanCaption && p.ignored[filepath.Clean(a.issues[i].Path)] {
+			a.removeIssue(i)
+		}
+	}
+	return true
+}
+
+// encodeDatasetConfig formats cfg the way it is stored in the dataset root
+func encodeDatasetConfig(cfg DatasetConfig) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// GetDatasetConfig returns the settings of the loaded dataset
+func (a *App) GetDatasetConfig() DatasetConfig {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.config
+}
+
+// SaveDatasetConfig validates and writes the settings to the dataset folder.
+// New pairing rules pick the caption file of the loaded items again and apply to
+// the watcher right away; media and sidecars newly matched by them and walk
+// changes take effect on the next ScanFolder. A new caption mode re-parses the
+// loaded captions; a new tag dictionary, segmenter dictionary or phrase
+// stopwords and blocklist are loaded immediately.
+func (a *App) SaveDatasetConfig(cfg DatasetConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.datasetPath == "" {
+		return fmt.Errorf("no dataset folder loaded")
+	}
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	dictionary := a.dictionary
+	if cfg.Dictionary != a.config.Dictionary {
+		d, err := loadTagDictionary(a.source, a.datasetPath, cfg.Dictionary)
+		if err != nil {
+			return err
+		}
+		dictionary = d
+	}
+	segmenter := a.segmenter
+	if !cfg.Segment.equal(a.config.Segment) {
+		s, err := loadSegmenter(a.source, a.datasetPath, cfg.Segment)
+		if s == nil {
+			return err
+		}
+		// 内置词典的错误扫描时已经报告过，这里照常保存
+		segmenter = s
+	}
+	// 短语设置没变时保留本次会话刷新统计时用的选项
+	phrases, filter := a.phraseConfig, a.phraseFilter
+	if cfg.Phrases != a.config.Phrases {
+		f, err := loadPhraseFilter(a.source, a.datasetPath, cfg.Phrases)
+		if err != nil {
+			return err
+		}
+		phrases, filter = cfg.Phrases, f
+	}
+	data, err := encodeDatasetConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := a.prepareWrite(false); err != nil {
+		return err
+	}
+	if err := a.writeDatasetFile(filepath.Join(a.datasetPath, datasetConfigFile), data); err != nil {
+		return err
+	}
+	reparse := a.config.Caption != cfg.Caption
+	repair := !reflect.DeepEqual(a.config.Pairing, cfg.Pairing)
+	a.config = cfg
+	a.dictionary = dictionary
+	a.segmenter = segmenter
+	a.phraseConfig = phrases
+	a.phraseFilter = filter
+	if repair {
+		a.pairing = newPairer(a.datasetPath, cfg.Pairing)
+		a.repairCaptions()
+	}
+	if a.ignoreConfigFiles() || repair {
+		if a.watcher != nil {
+			// 监听使用新的配对规则重新开始
+			a.startWatcher(a.watcher.interval)
+		}
+	}
+	if reparse {
+		a.reparseCaptions()
+	}
+	return a.flushArchive()
+}
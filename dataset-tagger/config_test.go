@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveDatasetConfigRepairsCaptions(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":     "",
+		"a.txt":     "from txt",
+		"a.caption": "from caption",
+		"b.png":     "",
+		"b.txt":     "only txt",
+	})
+	app := scanTestDataset(t, root)
+	if got := testItem(t, app, "a.png").RawTags; got != "from txt" {
+		t.Fatalf("caption before = %q, want the .txt file", got)
+	}
+
+	cfg := app.GetDatasetConfig()
+	cfg.Pairing.CaptionExts = []string{".caption", ".txt"}
+	cfg.Pairing.PrimaryCaption = ".caption"
+	if err := app.SaveDatasetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	a := testItem(t, app, "a.png")
+	if a.RawTags != "from caption" || a.TxtPath != filepath.Join(root, "a.caption") {
+		t.Fatalf("a = %q from %s, want the .caption file", a.RawTags, a.TxtPath)
+	}
+	if b := testItem(t, app, "b.png"); b.RawTags != "only txt" {
+		t.Fatalf("b = %q, want its .txt file kept", b.RawTags)
+	}
+	if got := app.pairing.captionPath(a.MediaPath); got != filepath.Join(root, "a.caption") {
+		t.Fatalf("new captions written to %s, want the .caption file", got)
+	}
+	if app.tagFrequency["from txt"] != 0 || app.tagFrequency["from caption"] != 1 {
+		t.Fatalf("tag frequency not updated: %v", app.tagFrequency)
+	}
+}
+
+func TestRefreshTagStatsDoesNotWriteArchive(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "set.tar.gz")
+	files := map[string][]byte{"set/a.txt": []byte("blue sky"), "set/b.txt": []byte("blue sky")}
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2)))
+	files["set/a.png"], files["set/b.png"] = img.Bytes(), img.Bytes()
+	writeTarGz(t, archivePath, files, []string{"set/a.png", "set/a.txt", "set/b.png", "set/b.txt"})
+
+	app := NewApp()
+	if result := app.scanFolder(context.Background(), archivePath, nil); !result.Success {
+		t.Fatal(result.Message)
+	}
+	opts := app.GetDatasetConfig().Phrases
+	opts.MinDocs = 1
+	if result := app.RefreshTagStats(opts); result["success"] != true {
+		t.Fatalf("refresh failed: %v", result["message"])
+	}
+	if app.phraseConfig.MinDocs != 1 {
+		t.Fatalf("refresh did not apply the options: %+v", app.phraseConfig)
+	}
+	if app.config.Phrases.MinDocs == 1 {
+		t.Fatal("refresh changed the saved dataset config")
+	}
+	if _, err := os.Stat(archiveExtractDir(archivePath)); !os.IsNotExist(err) {
+		t.Fatalf("refresh extracted the archive: %v", err)
+	}
+	if app.loadedArchive() == nil {
+		t.Fatal("dataset no longer read from the archive")
+	}
+}
+
+func TestSavePhraseConfig(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{"a.png": "", "a.txt": "blue sky"})
+	app := scanTestDataset(t, root)
+	configPath := filepath.Join(root, datasetConfigFile)
+
+	opts := app.GetDatasetConfig().Phrases
+	opts.MinDocs = 1
+	app.RefreshTagStats(opts)
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Fatalf("refresh wrote %s", datasetConfigFile)
+	}
+
+	// 保存其他设置不会丢掉本次会话的短语选项
+	cfg := app.GetDatasetConfig()
+	cfg.KeepTokens.Tokens = []string{"mychar"}
+	if err := app.SaveDatasetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if app.phraseConfig.MinDocs != 1 {
+		t.Fatalf("saving other settings reset the phrase options: %+v", app.phraseConfig)
+	}
+
+	if err := app.SavePhraseConfig(opts); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadDatasetConfig(osSource{}, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Phrases != opts || app.config.Phrases != opts {
+		t.Fatalf("saved phrases = %+v, want %+v", saved.Phrases, opts)
+	}
+}
+
+func TestPhraseConfigMaxDocRatio(t *testing.T) {
+	for _, tt := range []struct {
+		json    string
+		want    float64
+		wantErr bool
+	}{
+		{`{}`, 1, false},
+		{`{"maxDocRatio": 0.5}`, 0.5, false},
+		{`{"maxDocRatio": 0}`, 0, true},
+		{`{"maxDocRatio": 1.5}`, 1.5, true},
+	} {
+		var cfg PhraseConfig
+		if err := json.Unmarshal([]byte(tt.json), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.normalize()
+		err := cfg.validate()
+		if cfg.MaxDocRatio != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: maxDocRatio %v, err %v; want %v, error %v", tt.json, cfg.MaxDocRatio, err, tt.want, tt.wantErr)
+		}
+	}
+
+	root := writeTestDataset(t, map[string]string{
+		datasetConfigFile: `{"caption": {"mode": "tags"}}`,
+	})
+	cfg, err := loadDatasetConfig(osSource{}, root)
+	if err != nil || cfg.Phrases.MaxDocRatio != 1 {
+		t.Fatalf("config without phrases: maxDocRatio %v, err %v", cfg.Phrases.MaxDocRatio, err)
+	}
+	os.WriteFile(filepath.Join(root, datasetConfigFile), []byte(`{"phrases": {"maxDocRatio": 0}}`), 0o644)
+	if _, err := loadDatasetConfig(osSource{}, root); err == nil {
+		t.Fatal("maxDocRatio 0 loaded without an error")
+	}
+
+	app := scanTestDataset(t, writeTestDataset(t, map[string]string{"a.png": "", "a.txt": "x"}))
+	opts := app.GetDatasetConfig().Phrases
+	opts.MaxDocRatio = 0
+	if result := app.RefreshTagStats(opts); result["success"] != false {
+		t.Fatal("refresh accepted maxDocRatio 0")
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Co-occurrence pair sort orders
+const (
+	CooccurSortCount       = "count"
+	CooccurSortLift        = "lift"
+	CooccurSortPMI         = "pmi"
+	CooccurSortConditional = "conditional"
+)
+
+var cooccurSorts = []string{CooccurSortCount, CooccurSortLift, CooccurSortPMI, CooccurSortConditional}
+
+// CooccurrenceOptions selects the tags GetTagCooccurrence relates
+type CooccurrenceOptions struct {
+	// Top is the number of tags analysed: the most frequent ones, or with Tag set
+	// the ones most often found together with it; default 30
+	Top int `json:"top"`
+	// Tag, when set, lists the neighbours of this tag instead of a matrix of the
+	// top tags
+	Tag string `json:"tag"`
+	// MinCount drops pairs found together in fewer captions; default 1
+	MinCount int `json:"minCount"`
+	// Sort orders the pairs by count, lift, pmi or conditional (P(B|A)), highest
+	// first; default count
+	Sort string `json:"sort"`
+}
+
+func (o *CooccurrenceOptions) normalize() {
+	if o.Top <= 0 {
+		o.Top = 30
+	}
+	if o.MinCount <= 0 {
+		o.MinCount = 1
+	}
+	if o.Sort == "" {
+		o.Sort = CooccurSortCount
+	}
+}
+
+// TagPair relates two tags found in the same captions. Lift is how much more
+// often they meet than if they were independent and PMI its base-2 log;
+// BGivenA is P(B|A), the share of the captions with A that also have B.
+type TagPair struct {
+	A       string  `json:"a"`
+	B       string  `json:"b"`
+	Count   int     `json:"count"`
+	Lift    float64 `json:"lift"`
+	PMI     float64 `json:"pmi"`
+	BGivenA float64 `json:"bGivenA"`
+	AGivenB float64 `json:"aGivenB"`
+}
+
+// TagCooccurrence is the result of GetTagCooccurrence: the analysed tags with
+// their caption counts and the pairs among them in the requested order
+type TagCooccurrence struct {
+	Items int       `json:"items"`
+	Tags  []TagInfo `json:"tags"`
+	Pairs []TagPair `json:"pairs"`
+}
+
+// tagCooccurrence relates the tags of the items with itemIDs; the caller must
+// hold a.mu
+func (a *App) tagCooccurrence(itemIDs []string, opts CooccurrenceOptions) (TagCooccurrence, error) {
+	opts.normalize()
+	if !containsString(cooccurSorts, opts.Sort) {
+		return TagCooccurrence{}, fmt.Errorf("unknown co-occurrence sort: %s", opts.Sort)
+	}
+	// 每个标注里的标签只算一次
+	wanted := toSet(itemIDs)
+	docs := make([][]string, 0, len(itemIDs))
+	counts := make(map[string]int)
+	for _, item := range a.items {
+		if !wanted[item.ID] {
+			continue
+		}
+		tags := uniqueStrings(item.Tags)
+		for _, tag := range tags {
+			counts[tag]++
+		}
+		docs = append(docs, tags)
+	}
+	if opts.Tag != "" && counts[opts.Tag] == 0 {
+		return TagCooccurrence{}, fmt.Errorf("tag not found in these items: %s", opts.Tag)
+	}
+
+	// 邻居模式下按与该标签同时出现的次数挑选标签
+	rankBy := counts
+	if opts.Tag != "" {
+		rankBy = make(map[string]int)
+		for _, tags := range docs {
+			if containsString(tags, opts.Tag) {
+				for _, tag := range tags {
+					if tag != opts.Tag {
+						rankBy[tag]++
+					}
+				}
+			}
+		}
+	}
+	top := make([]string, 0, len(rankBy))
+	for tag := range rankBy {
+		top = append(top, tag)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if rankBy[top[i]] != rankBy[top[j]] {
+			return rankBy[top[i]] > rankBy[top[j]]
+		}
+		return top[i] < top[j]
+	})
+	if len(top) > opts.Top {
+		top = top[:opts.Top]
+	}
+	if opts.Tag != "" {
+		top = append([]string{opts.Tag}, top...)
+	}
+
+	index := make(map[string]int, len(top))
+	for i, tag := range top {
+		index[tag] = i
+	}
+	together := make([][]int, len(top))
+	for i := range together {
+		together[i] = make([]int, len(top))
+	}
+	for _, tags := range docs {
+		present := make([]int, 0, len(tags))
+		for _, tag := range tags {
+			if i, ok := index[tag]; ok {
+				present = append(present, i)
+			}
+		}
+		for x, i := range present {
+			for _, j := range present[x+1:] {
+				together[i][j]++
+				together[j][i]++
+			}
+		}
+	}
+
+	result := TagCooccurrence{Items: len(docs), Tags: make([]TagInfo, len(top)), Pairs: make([]TagPair, 0)}
+	for i, tag := range top {
+		result.Tags[i] = TagInfo{Tag: tag, Count: counts[tag]}
+	}
+	a.categorizeTags(result.Tags)
+	n := float64(len(docs))
+	for i := range top {
+		// 邻居模式只列出与该标签的组合
+		if opts.Tag != "" && i > 0 {
+			break
+		}
+		for j := i + 1; j < len(top); j++ {
+			c := together[i][j]
+			if c < opts.MinCount {
+				continue
+			}
+			ca, cb := float64(counts[top[i]]), float64(counts[top[j]])
+			lift := float64(c) * n / (ca * cb)
+			result.Pairs = append(result.Pairs, TagPair{
+				A:       top[i],
+				B:       top[j],
+				Count:   c,
+				Lift:    lift,
+				PMI:     math.Log2(lift),
+				BGivenA: float64(c) / ca,
+				AGivenB: float64(c) / cb,
+			})
+		}
+	}
+	sortTagPairs(result.Pairs, opts.Sort)
+	return result, nil
+}
+
+// sortTagPairs orders pairs by the by metric, highest first; ties fall back to
+// the count, then the tags
+func sortTagPairs(pairs []TagPair, by string) {
+	metric := func(p TagPair) float64 {
+		switch by {
+		case CooccurSortLift:
+			return p.Lift
+		case CooccurSortPMI:
+			return p.PMI
+		case CooccurSortConditional:
+			return p.BGivenA
+		}
+		return float64(p.Count)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if mi, mj := metric(pairs[i]), metric(pairs[j]); mi != mj {
+			return mi > mj
+		}
+		if pairs[i].Count != pairs[j].Count {
+			return pairs[i].Count > pairs[j].Count
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+}
+
+func uniqueStrings(list []string) []string {
+	result := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// GetTagCooccurrence relates the exact tags of the items with itemIDs (pass
+// the filtered items to respect the current filter): how often each pair of
+// the top tags is found in the same caption, their lift and PMI, and the
+// conditional probabilities both ways
+func (a *App) GetTagCooccurrence(itemIDs []string, opts CooccurrenceOptions) (TagCooccurrence, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.tagCooccurrence(itemIDs, opts)
+}
+
+// writeCooccurrenceCSV writes one row per pair with a header row
+func writeCooccurrenceCSV(w io.Writer, c TagCooccurrence) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"a", "b", "count", "lift", "pmi", "p_b_given_a", "p_a_given_b"})
+	for _, p := range c.Pairs {
+		cw.Write([]string{
+			p.A,
+			p.B,
+			strconv.Itoa(p.Count),
+			strconv.FormatFloat(p.Lift, 'f', 4, 64),
+			strconv.FormatFloat(p.PMI, 'f', 4, 64),
+			strconv.FormatFloat(p.BGivenA, 'f', 4, 64),
+			strconv.FormatFloat(p.AGivenB, 'f', 4, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// GraphML document for writeCooccurrenceGraphML
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// writeCooccurrenceGraphML writes the tags as nodes and the pairs as undirected
+// edges, for Gephi, Cytoscape or yEd
+func writeCooccurrenceGraphML(w io.Writer, c TagCooccurrence) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", Name: "label", Type: "string"},
+			{ID: "count", For: "node", Name: "count", Type: "int"},
+			{ID: "category", For: "node", Name: "category", Type: "string"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+			{ID: "lift", For: "edge", Name: "lift", Type: "double"},
+			{ID: "pmi", For: "edge", Name: "pmi", Type: "double"},
+			{ID: "p_b_given_a", For: "edge", Name: "p_b_given_a", Type: "double"},
+			{ID: "p_a_given_b", For: "edge", Name: "p_a_given_b", Type: "double"},
+		},
+		Graph: graphMLGraph{EdgeDefault: "undirected"},
+	}
+	ids := make(map[string]string, len(c.Tags))
+	for i, tag := range c.Tags {
+		ids[tag.Tag] = "n" + strconv.Itoa(i)
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: ids[tag.Tag], Data: []graphMLData{
+			{Key: "label", Value: tag.Tag},
+			{Key: "count", Value: strconv.Itoa(tag.Count)},
+			{Key: "category", Value: tag.Category},
+		}})
+	}
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, p := range c.Pairs {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: ids[p.A], Target: ids[p.B], Data: []graphMLData{
+			{Key: "weight", Value: strconv.Itoa(p.Count)},
+			{Key: "lift", Value: float(p.Lift)},
+			{Key: "pmi", Value: float(p.PMI)},
+			{Key: "p_b_given_a", Value: float(p.BGivenA)},
+			{Key: "p_a_given_b", Value: float(p.AGivenB)},
+		}})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Co-occurrence export formats
+const (
+	CooccurrenceCSV     = "csv"
+	CooccurrenceGraphML = "graphml"
+)
+
+// ExportTagCooccurrence asks for a file and writes the co-occurrence of the
+// items with itemIDs to it as CSV or GraphML; it returns the path written, or
+// "" when the dialog was cancelled
+func (a *App) ExportTagCooccurrence(itemIDs []string, opts CooccurrenceOptions, format string) (string, error) {
+	write := writeCooccurrenceCSV
+	filter := runtime.FileFilter{DisplayName: "CSV (*.csv)", Pattern: "*.csv"}
+	switch format {
+	case CooccurrenceCSV:
+	case CooccurrenceGraphML:
+		write = writeCooccurrenceGraphML
+		filter = runtime.FileFilter{DisplayName: "GraphML (*.graphml)", Pattern: "*.graphml"}
+	default:
+		return "", fmt.Errorf("unknown export format: %s", format)
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "导出标签共现",
+		DefaultFilename: "tag-cooccurrence." + format,
+		Filters:         []runtime.FileFilter{filter},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, err := a.tagCooccurrence(itemIDs, opts)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := write(f, c); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
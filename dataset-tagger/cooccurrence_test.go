@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// cooccurrenceTestApp loads four captions, plus one item left out of the
+// returned IDs as if filtered away
+func cooccurrenceTestApp(t *testing.T) (*App, []string) {
+	t.Helper()
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "", "a.txt": "1girl, solo, smile",
+		"b.png": "", "b.txt": "1girl, solo",
+		"c.png": "", "c.txt": "1girl, smile, smile",
+		"d.png": "", "d.txt": "1boy, solo",
+		"e.png": "", "e.txt": "1boy, smile",
+	})
+	app := scanTestDataset(t, root)
+	ids := make([]string, 0)
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		ids = append(ids, testItem(t, app, name).ID)
+	}
+	return app, ids
+}
+
+func pairNames(pairs []TagPair) [][2]string {
+	names := make([][2]string, len(pairs))
+	for i, p := range pairs {
+		names[i] = [2]string{p.A, p.B}
+	}
+	return names
+}
+
+func TestTagCooccurrenceMetrics(t *testing.T) {
+	app, ids := cooccurrenceTestApp(t)
+
+	c, err := app.GetTagCooccurrence(ids, CooccurrenceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Items != 4 {
+		t.Fatalf("items = %d, want 4 (e is filtered out)", c.Items)
+	}
+	tags := make(map[string]int)
+	for _, tag := range c.Tags {
+		tags[tag.Tag] = tag.Count
+	}
+	// 重复的标签在一个标注里只算一次
+	if want := map[string]int{"1girl": 3, "solo": 3, "smile": 2, "1boy": 1}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("tag counts = %v, want %v", tags, want)
+	}
+	want := [][2]string{{"1girl", "smile"}, {"1girl", "solo"}, {"solo", "1boy"}, {"solo", "smile"}}
+	if got := pairNames(c.Pairs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pairs by count = %q, want %q", got, want)
+	}
+
+	p := c.Pairs[0]
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	// 1girl 出现在 3 个标注中，smile 在 2 个，同时出现 2 次，共 4 个标注
+	if p.Count != 2 || !near(p.Lift, 4.0/3) || !near(p.PMI, math.Log2(4.0/3)) || !near(p.BGivenA, 2.0/3) || !near(p.AGivenB, 1) {
+		t.Fatalf("1girl/smile = %+v", p)
+	}
+
+	c, err = app.GetTagCooccurrence(ids, CooccurrenceOptions{Sort: CooccurSortLift})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][2]string{{"1girl", "smile"}, {"solo", "1boy"}, {"1girl", "solo"}, {"solo", "smile"}}
+	if got := pairNames(c.Pairs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pairs by lift = %q, want %q", got, want)
+	}
+
+	c, err = app.GetTagCooccurrence(ids, CooccurrenceOptions{MinCount: 2, Top: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pairNames(c.Pairs); !reflect.DeepEqual(got, [][2]string{{"1girl", "solo"}}) || len(c.Tags) != 2 {
+		t.Fatalf("top 2 with min count 2 = %q, %d tags", got, len(c.Tags))
+	}
+
+	if _, err := app.GetTagCooccurrence(ids, CooccurrenceOptions{Sort: "jaccard"}); err == nil {
+		t.Fatal("unknown sort accepted")
+	}
+}
+
+func TestTagCooccurrenceNeighbours(t *testing.T) {
+	app, ids := cooccurrenceTestApp(t)
+
+	c, err := app.GetTagCooccurrence(ids, CooccurrenceOptions{Tag: "solo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Tags[0].Tag != "solo" {
+		t.Fatalf("first tag = %s, want the requested tag", c.Tags[0].Tag)
+	}
+	want := [][2]string{{"solo", "1girl"}, {"solo", "1boy"}, {"solo", "smile"}}
+	if got := pairNames(c.Pairs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("neighbours = %q, want %q", got, want)
+	}
+	if p := c.Pairs[1]; p.BGivenA != 1.0/3 || p.AGivenB != 1 {
+		t.Fatalf("solo/1boy = %+v", p)
+	}
+
+	// 只传入 a 时其中没有 1boy
+	if _, err := app.GetTagCooccurrence(ids[:1], CooccurrenceOptions{Tag: "1boy"}); err == nil {
+		t.Fatal("neighbours of a tag missing from the items accepted")
+	}
+}
+
+func TestCooccurrenceExports(t *testing.T) {
+	app, ids := cooccurrenceTestApp(t)
+	c, err := app.GetTagCooccurrence(ids, CooccurrenceOptions{MinCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCooccurrenceCSV(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"a", "b", "count", "lift", "pmi", "p_b_given_a", "p_a_given_b"},
+		{"1girl", "smile", "2", "1.3333", "0.4150", "0.6667", "1.0000"},
+		{"1girl", "solo", "2", "0.8889", "-0.1699", "0.6667", "0.6667"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("csv = %q, want %q", rows, want)
+	}
+
+	buf.Reset()
+	if err := writeCooccurrenceGraphML(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	for _, node := range doc.Graph.Nodes {
+		labels[node.ID] = node.Data[0].Value
+	}
+	if len(labels) != len(c.Tags) || len(doc.Graph.Edges) != len(c.Pairs) {
+		t.Fatalf("graphml has %d nodes and %d edges", len(labels), len(doc.Graph.Edges))
+	}
+	for i, edge := range doc.Graph.Edges {
+		if labels[edge.Source] != c.Pairs[i].A || labels[edge.Target] != c.Pairs[i].B || edge.Data[0].Value != "2" {
+			t.Errorf("edge %d = %s-%s weight %s", i, labels[edge.Source], labels[edge.Target], edge.Data[0].Value)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Tag categories, in the order they are listed in stats
+const (
+	CategoryCharacter = "character"
+	CategoryCopyright = "copyright"
+	CategoryArtist    = "artist"
+	CategoryGeneral   = "general"
+	CategoryMeta      = "meta"
+	// CategoryUnknown is used for tags missing from the dictionary
+	CategoryUnknown = "unknown"
+)
+
+var tagCategories = []string{CategoryCharacter, CategoryCopyright, CategoryArtist, CategoryGeneral, CategoryMeta}
+
+// Dictionary formats: they differ in how category numbers are assigned
+const (
+	DictionaryDanbooru = "danbooru"
+	DictionaryE621     = "e621"
+)
+
+// dictionaryCategoryIDs maps the numeric category column of each format.
+// e621 species, invalid and lore tags are folded into general and meta.
+var dictionaryCategoryIDs = map[string]map[int]string{
+	DictionaryDanbooru: {0: CategoryGeneral, 1: CategoryArtist, 3: CategoryCopyright, 4: CategoryCharacter, 5: CategoryMeta},
+	DictionaryE621:     {0: CategoryGeneral, 1: CategoryArtist, 3: CategoryCopyright, 4: CategoryCharacter, 5: CategoryGeneral, 6: CategoryMeta, 7: CategoryMeta, 8: CategoryMeta},
+}
+
+// DictionaryConfig points at a local tag dictionary in tags.csv format
+// (name, category, post count). A relative path is resolved against the dataset
+// root, so a dictionary kept with the dataset works for every teammate.
+type DictionaryConfig struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+func defaultDictionaryConfig() DictionaryConfig {
+	return DictionaryConfig{Format: DictionaryDanbooru}
+}
+
+func (c *DictionaryConfig) normalize() {
+	c.Path = strings.TrimSpace(c.Path)
+	c.Format = strings.ToLower(strings.TrimSpace(c.Format))
+	if c.Format == "" {
+		c.Format = DictionaryDanbooru
+	}
+}
+
+func (c *DictionaryConfig) validate() error {
+	if _, ok := dictionaryCategoryIDs[c.Format]; !ok {
+		return fmt.Errorf("unknown dictionary format: %s", c.Format)
+	}
+	return nil
+}
+
+// tagDictionary maps tags to their category
+type tagDictionary struct {
+	categories map[string]string
+}
+
+// dictionaryKey matches "blue_eyes", "Blue Eyes" and "blue eyes" to one entry
+func dictionaryKey(tag string) string {
+	return strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, "_", " ")))
+}
+
+// category returns the category of a bare tag, or "" when it is unknown
+func (d *tagDictionary) category(tag string) string {
+	if d == nil {
+		return ""
+	}
+	return d.categories[dictionaryKey(tag)]
+}
+
+// parseTagDictionary reads a tags.csv: name, category and optionally post count
+// and aliases. A header row is skipped; categories may be numbers or names.
+func parseTagDictionary(r io.Reader, format string) (*tagDictionary, error) {
+	ids := dictionaryCategoryIDs[format]
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	d := &tagDictionary{categories: make(map[string]string)}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 2 {
+			continue
+		}
+		value := strings.ToLower(strings.TrimSpace(row[1]))
+		category := ""
+		if n, err := strconv.Atoi(value); err == nil {
+			category = ids[n]
+		} else if containsString(tagCategories, value) {
+			category = value
+		}
+		if name := dictionaryKey(row[0]); name != "" && category != "" {
+			d.categories[name] = category
+		}
+	}
+	return d, nil
+}
+
+// dictionaryPath resolves the configured dictionary against the dataset root
+func dictionaryPath(root string, cfg DictionaryConfig) string {
+	return configFilePath(root, cfg.Path)
+}
+
+// configFilePath resolves a file named in the dataset config: relative paths are
+// relative to the dataset root
+func configFilePath(root string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// readConfigFile reads a file named in the dataset config. Paths inside the
+// dataset are read through src so archived datasets work too.
+func readConfigFile(src datasetSource, root string, path string) ([]byte, error) {
+	if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return src.ReadFile(path)
+	}
+	return os.ReadFile(path)
+}
+
+// loadTagDictionary reads the configured dictionary; no path yields nil
+func loadTagDictionary(src datasetSource, root string, cfg DictionaryConfig) (*tagDictionary, error) {
+	path := dictionaryPath(root, cfg)
+	if path == "" {
+		return nil, nil
+	}
+	data, err := readConfigFile(src, root, path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseTagDictionary(bytes.NewReader(data), cfg.Format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return d, nil
+}
+
+// tagCategory returns the category of a bare tag, looking aliases up under their
+// canonical tag; the caller must hold a.mu
+func (a *App) tagCategory(tag string, rules TagRules) string {
+	if a.dictionary == nil {
+		return ""
+	}
+	if category := a.dictionary.category(rules.canonical(tag)); category != "" {
+		return category
+	}
+	return CategoryUnknown
+}
+
+// categorizeTags fills in the category of the phrases that are dictionary tags;
+// other phrases keep no category. The caller must hold a.mu.
+func (a *App) categorizeTags(tags []TagInfo) {
+	if a.dictionary == nil {
+		return
+	}
+	rules := a.tagRules()
+	for i := range tags {
+		tags[i].Category = a.dictionary.category(rules.canonical(tags[i].Tag))
+	}
+}
+
+// itemCategoryCounts counts the tags of item per category; the caller must hold a.mu
+func (a *App) itemCategoryCounts(item DatasetItem, rules TagRules) map[string]int {
+	counts := make(map[string]int, len(tagCategories))
+	for _, tag := range item.Tags {
+		counts[a.tagCategory(tag, rules)]++
+	}
+	return counts
+}
+
+// CategoryStat is the share of one category among the exact tags of the dataset
+type CategoryStat struct {
+	Category string `json:"category"`
+	// Tags is the number of distinct tags, Count the number of occurrences
+	Tags  int `json:"tags"`
+	Count int `json:"count"`
+}
+
+// categoryStats breaks the exact tags down by category; it is empty without a
+// dictionary. The caller must hold a.mu.
+func (a *App) categoryStats() []CategoryStat {
+	stats := make([]CategoryStat, 0)
+	if a.dictionary == nil {
+		return stats
+	}
+	rules := a.tagRules()
+	byCategory := make(map[string]*CategoryStat)
+	for tag, n := range a.tagFrequency {
+		category := a.tagCategory(tag, rules)
+		s := byCategory[category]
+		if s == nil {
+			s = &CategoryStat{Category: category}
+			byCategory[category] = s
+		}
+		s.Tags++
+		s.Count += n
+	}
+	for _, category := range tagCategories {
+		if s := byCategory[category]; s != nil {
+			stats = append(stats, *s)
+		}
+	}
+	if s := byCategory[CategoryUnknown]; s != nil {
+		stats = append(stats, *s)
+	}
+	return stats
+}
+
+// GetCategoryStats returns the per-category breakdown of the exact tags
+func (a *App) GetCategoryStats() []CategoryStat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.categoryStats()
+}
+
+// ImportTagDictionary sets the dataset's tag dictionary to a local tags.csv in
+// format "danbooru" or "e621", saves it in the dataset config and returns the
+// number of tags read. A file inside the dataset is stored with a relative path.
+func (a *App) ImportTagDictionary(path string, format string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.datasetPath == "" {
+		return 0, fmt.Errorf("no dataset folder loaded")
+	}
+	cfg := DictionaryConfig{Path: path, Format: format}
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		return 0, err
+	}
+	if rel, err := filepath.Rel(a.datasetPath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		cfg.Path = filepath.ToSlash(rel)
+	}
+	d, err := loadTagDictionary(a.source, a.datasetPath, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	config := a.config
+	config.Dictionary = cfg
+	data, err := encodeDatasetConfig(config)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := a.prepareWrite(false); err != nil {
+		return 0, err
+	}
+	if err := a.writeDatasetFile(filepath.Join(a.datasetPath, datasetConfigFile), data); err != nil {
+		return 0, err
+	}
+	a.config = config
+	a.dictionary = d
+	return len(d.categories), a.flushArchive()
+}
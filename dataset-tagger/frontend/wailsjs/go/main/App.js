@@ -2,22 +2,86 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function BatchAddConceptTag(arg1, arg2) {
+  return window['go']['main']['App']['BatchAddConceptTag'](arg1, arg2);
+}
+
 export function BatchAddTag(arg1, arg2, arg3) {
   return window['go']['main']['App']['BatchAddTag'](arg1, arg2, arg3);
 }
 
+export function BatchApplyKeepTokens(arg1) {
+  return window['go']['main']['App']['BatchApplyKeepTokens'](arg1);
+}
+
+export function BatchApplyTagRules(arg1) {
+  return window['go']['main']['App']['BatchApplyTagRules'](arg1);
+}
+
+export function BatchNormalizeTags(arg1) {
+  return window['go']['main']['App']['BatchNormalizeTags'](arg1);
+}
+
 export function BatchRemoveTag(arg1, arg2, arg3) {
   return window['go']['main']['App']['BatchRemoveTag'](arg1, arg2, arg3);
 }
 
-export function BatchReplaceTag(arg1, arg2, arg3, arg4) {
-  return window['go']['main']['App']['BatchReplaceTag'](arg1, arg2, arg3, arg4);
+export function BatchReorderTags(arg1, arg2) {
+  return window['go']['main']['App']['BatchReorderTags'](arg1, arg2);
+}
+
+export function BatchReplaceTag(arg1, arg2, arg3, arg4, arg5) {
+  return window['go']['main']['App']['BatchReplaceTag'](arg1, arg2, arg3, arg4, arg5);
+}
+
+export function CancelScan() {
+  return window['go']['main']['App']['CancelScan']();
+}
+
+export function ComparePhrases(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ComparePhrases'](arg1, arg2, arg3);
+}
+
+export function ExportTagCooccurrence(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ExportTagCooccurrence'](arg1, arg2, arg3);
+}
+
+export function ExportTagStatsCSV(arg1) {
+  return window['go']['main']['App']['ExportTagStatsCSV'](arg1);
+}
+
+export function FilterByExactTag(arg1) {
+  return window['go']['main']['App']['FilterByExactTag'](arg1);
 }
 
 export function FilterByTag(arg1) {
   return window['go']['main']['App']['FilterByTag'](arg1);
 }
 
+export function FilterItems(arg1, arg2) {
+  return window['go']['main']['App']['FilterItems'](arg1, arg2);
+}
+
+export function GetArchiveSaveMode() {
+  return window['go']['main']['App']['GetArchiveSaveMode']();
+}
+
+export function GetCategoryStats() {
+  return window['go']['main']['App']['GetCategoryStats']();
+}
+
+export function GetConceptStats() {
+  return window['go']['main']['App']['GetConceptStats']();
+}
+
+export function GetDatasetConfig() {
+  return window['go']['main']['App']['GetDatasetConfig']();
+}
+
+export function GetIssueActions(arg1) {
+  return window['go']['main']['App']['GetIssueActions'](arg1);
+}
+
 export function GetItemByID(arg1) {
   return window['go']['main']['App']['GetItemByID'](arg1);
 }
@@ -26,18 +90,82 @@ export function GetItems() {
   return window['go']['main']['App']['GetItems']();
 }
 
+export function GetKeepTokenIssues(arg1) {
+  return window['go']['main']['App']['GetKeepTokenIssues'](arg1);
+}
+
 export function GetPagedItems(arg1, arg2) {
   return window['go']['main']['App']['GetPagedItems'](arg1, arg2);
 }
 
+export function GetScanIssues() {
+  return window['go']['main']['App']['GetScanIssues']();
+}
+
+export function GetSortKeys() {
+  return window['go']['main']['App']['GetSortKeys']();
+}
+
+export function GetSortOrder() {
+  return window['go']['main']['App']['GetSortOrder']();
+}
+
+export function GetTagCooccurrence(arg1, arg2) {
+  return window['go']['main']['App']['GetTagCooccurrence'](arg1, arg2);
+}
+
+export function GetTagRuleIssues(arg1) {
+  return window['go']['main']['App']['GetTagRuleIssues'](arg1);
+}
+
+export function GetTagRules(arg1) {
+  return window['go']['main']['App']['GetTagRules'](arg1);
+}
+
+export function GetTagStats(arg1) {
+  return window['go']['main']['App']['GetTagStats'](arg1);
+}
+
 export function GetThumbnail(arg1, arg2) {
   return window['go']['main']['App']['GetThumbnail'](arg1, arg2);
 }
 
+export function ImportTagDictionary(arg1, arg2) {
+  return window['go']['main']['App']['ImportTagDictionary'](arg1, arg2);
+}
+
+export function ImportTagRulesCSV(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['ImportTagRulesCSV'](arg1, arg2, arg3, arg4);
+}
+
+export function IsWatching() {
+  return window['go']['main']['App']['IsWatching']();
+}
+
 export function OpenInExplorer(arg1) {
   return window['go']['main']['App']['OpenInExplorer'](arg1);
 }
 
+export function ParseCaptionTags(arg1) {
+  return window['go']['main']['App']['ParseCaptionTags'](arg1);
+}
+
+export function PreviewKeepTokens(arg1) {
+  return window['go']['main']['App']['PreviewKeepTokens'](arg1);
+}
+
+export function PreviewNormalize(arg1) {
+  return window['go']['main']['App']['PreviewNormalize'](arg1);
+}
+
+export function PreviewReorderTags(arg1, arg2) {
+  return window['go']['main']['App']['PreviewReorderTags'](arg1, arg2);
+}
+
+export function PreviewTagRules(arg1) {
+  return window['go']['main']['App']['PreviewTagRules'](arg1);
+}
+
 export function ReadMediaFile(arg1) {
   return window['go']['main']['App']['ReadMediaFile'](arg1);
 }
@@ -46,10 +174,38 @@ export function ReadTextFile(arg1) {
   return window['go']['main']['App']['ReadTextFile'](arg1);
 }
 
+export function RefreshTagStats(arg1) {
+  return window['go']['main']['App']['RefreshTagStats'](arg1);
+}
+
+export function RemoveCaptionTag(arg1, arg2) {
+  return window['go']['main']['App']['RemoveCaptionTag'](arg1, arg2);
+}
+
+export function ResolveIssue(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ResolveIssue'](arg1, arg2, arg3);
+}
+
+export function ResolveIssues(arg1, arg2) {
+  return window['go']['main']['App']['ResolveIssues'](arg1, arg2);
+}
+
 export function SaveAllChanges(arg1) {
   return window['go']['main']['App']['SaveAllChanges'](arg1);
 }
 
+export function SaveDatasetConfig(arg1) {
+  return window['go']['main']['App']['SaveDatasetConfig'](arg1);
+}
+
+export function SavePhraseConfig(arg1) {
+  return window['go']['main']['App']['SavePhraseConfig'](arg1);
+}
+
+export function SaveTagRules(arg1, arg2) {
+  return window['go']['main']['App']['SaveTagRules'](arg1, arg2);
+}
+
 export function SaveTags(arg1, arg2) {
   return window['go']['main']['App']['SaveTags'](arg1, arg2);
 }
@@ -58,10 +214,34 @@ export function ScanFolder(arg1) {
   return window['go']['main']['App']['ScanFolder'](arg1);
 }
 
+export function SelectArchive() {
+  return window['go']['main']['App']['SelectArchive']();
+}
+
+export function SelectCSVFile() {
+  return window['go']['main']['App']['SelectCSVFile']();
+}
+
 export function SelectFolder() {
   return window['go']['main']['App']['SelectFolder']();
 }
 
+export function SetArchiveSaveMode(arg1) {
+  return window['go']['main']['App']['SetArchiveSaveMode'](arg1);
+}
+
+export function SetSortOrder(arg1) {
+  return window['go']['main']['App']['SetSortOrder'](arg1);
+}
+
+export function StartWatching(arg1) {
+  return window['go']['main']['App']['StartWatching'](arg1);
+}
+
+export function StopWatching() {
+  return window['go']['main']['App']['StopWatching']();
+}
+
 export function StreamFile(arg1, arg2, arg3) {
   return window['go']['main']['App']['StreamFile'](arg1, arg2, arg3);
 }
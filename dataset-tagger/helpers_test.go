@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestDataset creates files under a temporary root: paths ending in .png get
+// a tiny image, every other path gets its content as text
+func writeTestDataset(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(content)
+		if filepath.Ext(name) == ".png" {
+			data = img.Bytes()
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// scanTestDataset loads root into a new App
+func scanTestDataset(t *testing.T, root string) *App {
+	t.Helper()
+	app := NewApp()
+	if result := app.scanFolder(context.Background(), root, nil); !result.Success {
+		t.Fatalf("scan %s: %s", root, result.Message)
+	}
+	return app
+}
+
+// testItem returns the item whose media file is name under the dataset root
+func testItem(t *testing.T, app *App, name string) DatasetItem {
+	t.Helper()
+	i := app.itemIndexByMedia(filepath.Join(app.datasetPath, filepath.FromSlash(name)))
+	if i == -1 {
+		t.Fatalf("no item for %s", name)
+	}
+	return app.items[i]
+}
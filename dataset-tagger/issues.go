@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Issue kinds reported by ScanFolder
+const (
+	IssueCollision      = "collision"       // 同目录下同名不同扩展名的媒体文件
+	IssueOrphanCaption  = "orphan_caption"  // 没有对应媒体文件的标注文件
+	IssueMissingCaption = "missing_caption" // 没有标注文件的媒体
+	IssueEmptyCaption   = "empty_caption"   // 标注文件为空
+	IssueUnreadable     = "unreadable"      // 无法读取的文件或目录
+)
+
+// Issue resolution actions accepted by ResolveIssue
+const (
+	ActionDelete        = "delete"         // 删除 Path 指向的文件
+	ActionRename        = "rename"         // 冲突媒体改名，使其拥有独立的标注文件
+	ActionCreateCaption = "create_caption" // 为媒体创建空标注文件
+	ActionIgnore        = "ignore"         // 只从报告中移除
+)
+
+// ScanIssue describes a problem found while pairing media and caption files
+type ScanIssue struct {
+	Kind    string   `json:"kind"`
+	Path    string   `json:"path"`
+	Related []string `json:"related,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+// issueActions lists the actions that make sense for each issue kind
+var issueActions = map[string][]string{
+	IssueCollision:      {ActionRename, ActionDelete, ActionIgnore},
+	IssueOrphanCaption:  {ActionDelete, ActionIgnore},
+	IssueMissingCaption: {ActionCreateCaption, ActionDelete, ActionIgnore},
+	IssueEmptyCaption:   {ActionDelete, ActionIgnore},
+	IssueUnreadable:     {ActionIgnore},
+}
+
+// sortIssues orders issues by kind, then path, so reports are stable
+func sortIssues(issues []ScanIssue) {
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Kind != issues[j].Kind {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Path < issues[j].Path
+	})
+}
+
+// GetScanIssues returns the issues found by the last scan that are still unresolved
+func (a *App) GetScanIssues() []ScanIssue {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.scanIssues()
+}
+
+func (a *App) scanIssues() []ScanIssue {
+	if a.issues == nil {
+		return []ScanIssue{}
+	}
+	return a.issues
+}
+
+// GetIssueActions returns the resolution actions available for an issue kind
+func (a *App) GetIssueActions(kind string) []string {
+	return issueActions[kind]
+}
+
+// ResolveIssue applies an action to one reported issue and returns the remaining issues.
+// For collisions the action applies to the first entry of Related, i.e. the media
+// file that lost the pairing.
+func (a *App) ResolveIssue(kind string, path string, action string) ([]ScanIssue, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rebase, err := a.prepareIssueWrite(kind, action)
+	if err != nil {
+		return a.scanIssues(), err
+	}
+	err = a.resolveIssue(kind, rebase(path), action)
+	if ferr := a.flushArchive(); err == nil {
+		err = ferr
+	}
+	return a.scanIssues(), err
+}
+
+// prepareIssueWrite readies an archived dataset for a resolution that touches files;
+// the caller must hold a.mu
+func (a *App) prepareIssueWrite(kind string, action string) (func(string) string, error) {
+	if action == ActionIgnore || !issueActionAllowed(kind, action) {
+		return func(p string) string { return p }, nil
+	}
+	// 删除、重命名需要真实文件；压缩包按保存方式解压或排队写回
+	return a.prepareWrite(action == ActionDelete || action == ActionRename)
+}
+
+func issueActionAllowed(kind string, action string) bool {
+	for _, act := range issueActions[kind] {
+		if act == action {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIssue applies one resolution; the caller must hold a.mu
+func (a *App) resolveIssue(kind string, path string, action string) error {
+	idx := a.issueIndex(kind, path)
+	if idx == -1 {
+		return fmt.Errorf("issue not found: %s %s", kind, path)
+	}
+	issue := a.issues[idx]
+
+	if !issueActionAllowed(kind, action) {
+		return fmt.Errorf("action %q is not valid for %s", action, kind)
+	}
+
+	var err error
+	switch {
+	case action == ActionIgnore:
+		a.removeIssue(idx)
+	case kind == IssueCollision:
+		err = a.resolveCollision(idx, issue, action)
+	case kind == IssueOrphanCaption, kind == IssueEmptyCaption:
+		err = a.deleteCaption(idx, issue)
+	case kind == IssueMissingCaption && action == ActionCreateCaption:
+		err = a.createEmptyCaption(idx, issue)
+	case kind == IssueMissingCaption && action == ActionDelete:
+		err = a.deleteMedia(idx, issue)
+	}
+	return err
+}
+
+// ResolveIssues applies the same action to every issue of a kind
+func (a *App) ResolveIssues(kind string, action string) ([]ScanIssue, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.prepareIssueWrite(kind, action); err != nil {
+		return a.scanIssues(), err
+	}
+	paths := make([]string, 0)
+	for _, issue := range a.issues {
+		if issue.Kind == kind {
+			paths = append(paths, issue.Path)
+		}
+	}
+	for _, path := range paths {
+		if err := a.resolveIssue(kind, path, action); err != nil {
+			a.flushArchive()
+			return a.scanIssues(), err
+		}
+	}
+	return a.scanIssues(), a.flushArchive()
+}
+
+func (a *App) removeIssue(idx int) {
+	a.issues = append(a.issues[:idx], a.issues[idx+1:]...)
+}
+
+func (a *App) addIssue(issue ScanIssue) {
+	a.issues = append(a.issues, issue)
+	sortIssues(a.issues)
+}
+
+// itemIndexByMedia finds the item that owns a media path
+func (a *App) itemIndexByMedia(mediaPath string) int {
+	for i, item := range a.items {
+		if item.MediaPath == mediaPath {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveCollision renames or deletes the media file that lost the pairing
+func (a *App) resolveCollision(idx int, issue ScanIssue, action string) error {
+	if len(issue.Related) == 0 {
+		a.removeIssue(idx)
+		return nil
+	}
+	loser := issue.Related[0]
+
+	if action == ActionDelete {
+		if err := os.Remove(loser); err != nil {
+			return err
+		}
+	} else {
+		newPath := uniqueMediaPath(a.pairing, loser)
+		if err := os.Rename(loser, newPath); err != nil {
+			return err
+		}
+		ext := filepath.Ext(newPath)
+		item := DatasetItem{
+			ID:        strings.TrimSuffix(newPath, ext),
+			MediaPath: newPath,
+			IsVideo:   a.pairing.isVideo(newPath),
+			Tags:      []string{},
+		}
+		applyKohyaInfo(a.datasetPath, &item)
+		applyMediaInfo(a.source, &item)
+		a.items = append(a.items, item)
+		a.applySortOrder()
+		a.addIssue(ScanIssue{Kind: IssueMissingCaption, Path: newPath})
+		idx = a.issueIndex(issue.Kind, issue.Path)
+	}
+
+	a.issues[idx].Related = a.issues[idx].Related[1:]
+	if len(a.issues[idx].Related) == 0 {
+		a.removeIssue(idx)
+	}
+	return nil
+}
+
+// deleteCaption removes an orphan or empty caption file
+func (a *App) deleteCaption(idx int, issue ScanIssue) error {
+	if err := os.Remove(issue.Path); err != nil {
+		return err
+	}
+	a.removeIssue(idx)
+
+	for i, item := range a.items {
+		if item.TxtPath == issue.Path {
+			a.adjustTagFrequency(item.Tags, -1)
+			a.items[i].TxtPath = ""
+			a.items[i].RawTags = ""
+			a.items[i].Tags = []string{}
+			a.addIssue(ScanIssue{Kind: IssueMissingCaption, Path: item.MediaPath})
+		}
+	}
+	return nil
+}
+
+// createEmptyCaption writes an empty caption file next to the media
+func (a *App) createEmptyCaption(idx int, issue ScanIssue) error {
+	i := a.itemIndexByMedia(issue.Path)
+	if i == -1 {
+		a.removeIssue(idx)
+		return nil
+	}
+	if err := a.saveTags(a.items[i].ID, ""); err != nil {
+		return err
+	}
+	a.removeIssue(idx)
+	return nil
+}
+
+// deleteMedia removes a media file that has no caption, along with its item
+func (a *App) deleteMedia(idx int, issue ScanIssue) error {
+	if err := os.Remove(issue.Path); err != nil {
+		return err
+	}
+	a.removeIssue(idx)
+
+	if i := a.itemIndexByMedia(issue.Path); i != -1 {
+		a.items = append(a.items[:i], a.items[i+1:]...)
+	}
+	return nil
+}
+
+func (a *App) issueIndex(kind, path string) int {
+	for i, issue := range a.issues {
+		if issue.Kind == kind && issue.Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// uniqueMediaPath returns a free path for a colliding media file, e.g. a.jpg -> a_jpg.jpg
+func uniqueMediaPath(p *pairer, path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "_" + strings.TrimPrefix(strings.ToLower(ext), ".")
+	candidate := base + ext
+	for n := 2; ; n++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			if _, err := os.Stat(p.captionPath(candidate)); os.IsNotExist(err) {
+				return candidate
+			}
+		}
+		base = fmt.Sprintf("%s_%s_%d", strings.TrimSuffix(path, ext), strings.TrimPrefix(strings.ToLower(ext), "."), n)
+		candidate = base + ext
+	}
+}
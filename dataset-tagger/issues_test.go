@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hasIssue reports whether issues contain kind for the file name under root
+func hasIssue(issues []ScanIssue, kind, root, name string) bool {
+	path := filepath.Join(root, filepath.FromSlash(name))
+	for _, issue := range issues {
+		if issue.Kind == kind && issue.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func fileExists(root, name string) bool {
+	_, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func TestResolveCaptionIssues(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":       "",
+		"a.txt":       "1girl",
+		"b.png":       "",
+		"b.txt":       "",
+		"orphan.txt":  "smile",
+		"orphan2.txt": "solo",
+	})
+	app := scanTestDataset(t, root)
+	path := func(name string) string { return filepath.Join(root, name) }
+
+	issues, err := app.ResolveIssue(IssueOrphanCaption, path("orphan.txt"), ActionDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, "orphan.txt") || hasIssue(issues, IssueOrphanCaption, root, "orphan.txt") {
+		t.Fatal("orphan caption not deleted")
+	}
+
+	issues, err = app.ResolveIssue(IssueOrphanCaption, path("orphan2.txt"), ActionIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(root, "orphan2.txt") || hasIssue(issues, IssueOrphanCaption, root, "orphan2.txt") {
+		t.Fatal("ignore should only drop the issue")
+	}
+
+	// 删除空标注后，媒体变成缺少标注
+	issues, err = app.ResolveIssue(IssueEmptyCaption, path("b.txt"), ActionDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, "b.txt") || hasIssue(issues, IssueEmptyCaption, root, "b.txt") {
+		t.Fatal("empty caption not deleted")
+	}
+	if !hasIssue(issues, IssueMissingCaption, root, "b.png") {
+		t.Fatalf("b.png not reported as missing a caption: %+v", issues)
+	}
+	if b := testItem(t, app, "b.png"); b.TxtPath != "" {
+		t.Fatalf("b.TxtPath = %q", b.TxtPath)
+	}
+	if a := testItem(t, app, "a.png"); a.TxtPath != path("a.txt") {
+		t.Fatalf("a.TxtPath = %q", a.TxtPath)
+	}
+}
+
+func TestResolveMissingCaption(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"c.png": "",
+		"d.png": "",
+	})
+	app := scanTestDataset(t, root)
+
+	issues, err := app.ResolveIssue(IssueMissingCaption, filepath.Join(root, "c.png"), ActionCreateCaption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "c.txt"))
+	if err != nil || len(data) != 0 {
+		t.Fatalf("c.txt = %q, %v", data, err)
+	}
+	if c := testItem(t, app, "c.png"); c.TxtPath != filepath.Join(root, "c.txt") {
+		t.Fatalf("c.TxtPath = %q", c.TxtPath)
+	}
+	if hasIssue(issues, IssueMissingCaption, root, "c.png") {
+		t.Fatal("missing caption still reported after creating it")
+	}
+
+	issues, err = app.ResolveIssue(IssueMissingCaption, filepath.Join(root, "d.png"), ActionDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, "d.png") || hasIssue(issues, IssueMissingCaption, root, "d.png") {
+		t.Fatal("media without caption not deleted")
+	}
+	if app.itemIndexByMedia(filepath.Join(root, "d.png")) != -1 {
+		t.Fatal("item of the deleted media still loaded")
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":  "",
+		"a.webp": "",
+		"a.txt":  "1girl",
+		"b.png":  "",
+		"b.webp": "",
+		"c.png":  "",
+		"c.webp": "",
+	})
+	app := scanTestDataset(t, root)
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		if !hasIssue(app.GetScanIssues(), IssueCollision, root, name) {
+			t.Fatalf("collision of %s not reported: %+v", name, app.GetScanIssues())
+		}
+	}
+
+	// 改名后落选的媒体成为独立项目，缺少标注
+	issues, err := app.ResolveIssue(IssueCollision, filepath.Join(root, "a.png"), ActionRename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, "a.webp") || !fileExists(root, "a_webp.webp") {
+		t.Fatal("colliding media not renamed to a_webp.webp")
+	}
+	if hasIssue(issues, IssueCollision, root, "a.png") || !hasIssue(issues, IssueMissingCaption, root, "a_webp.webp") {
+		t.Fatalf("issues after rename = %+v", issues)
+	}
+	testItem(t, app, "a_webp.webp")
+	if a := testItem(t, app, "a.png"); a.RawTags != "1girl" {
+		t.Fatalf("a.png lost its caption: %q", a.RawTags)
+	}
+
+	issues, err = app.ResolveIssue(IssueCollision, filepath.Join(root, "b.png"), ActionDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(root, "b.webp") || !fileExists(root, "b.png") || hasIssue(issues, IssueCollision, root, "b.png") {
+		t.Fatal("delete should remove only the media that lost the pairing")
+	}
+
+	issues, err = app.ResolveIssue(IssueCollision, filepath.Join(root, "c.png"), ActionIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(root, "c.webp") || hasIssue(issues, IssueCollision, root, "c.png") {
+		t.Fatal("ignore should only drop the issue")
+	}
+}
+
+func TestResolveIssueRejectsInvalidRequests(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"orphan.txt": "smile",
+	})
+	app := scanTestDataset(t, root)
+	path := filepath.Join(root, "orphan.txt")
+
+	if _, err := app.ResolveIssue(IssueOrphanCaption, path, ActionCreateCaption); err == nil {
+		t.Fatal("create_caption accepted for an orphan caption")
+	}
+	if _, err := app.ResolveIssue(IssueOrphanCaption, filepath.Join(root, "other.txt"), ActionDelete); err == nil {
+		t.Fatal("resolved an issue that was never reported")
+	}
+	if !fileExists(root, "orphan.txt") || !hasIssue(app.GetScanIssues(), IssueOrphanCaption, root, "orphan.txt") {
+		t.Fatal("rejected requests changed the dataset")
+	}
+}
+
+func TestResolveIssueRefusesFileChangesInRewrittenArchive(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2)))
+	files := map[string][]byte{
+		"set/a.png":      img.Bytes(),
+		"set/a.txt":      []byte("1girl"),
+		"set/b.png":      img.Bytes(),
+		"set/orphan.txt": []byte("smile"),
+	}
+	archivePath := filepath.Join(t.TempDir(), "set.tar.gz")
+	writeTarGz(t, archivePath, files, []string{"set/a.png", "set/a.txt", "set/b.png", "set/orphan.txt"})
+	before, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	if result := app.scanFolder(context.Background(), archivePath, nil); !result.Success {
+		t.Fatalf("scan: %s", result.Message)
+	}
+	if err := app.SetArchiveSaveMode(ArchiveSaveRewrite); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.ResolveIssue(IssueOrphanCaption, filepath.Join(archivePath, "set", "orphan.txt"), ActionDelete); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("delete inside an archive: %v", err)
+	}
+	if _, err := app.ResolveIssues(IssueMissingCaption, ActionDelete); err == nil {
+		t.Fatal("batch delete inside an archive accepted")
+	}
+	after, err := os.ReadFile(archivePath)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("archive changed by a refused resolution: %v", err)
+	}
+	if !hasIssue(app.GetScanIssues(), IssueOrphanCaption, archivePath, "set/orphan.txt") ||
+		!hasIssue(app.GetScanIssues(), IssueMissingCaption, archivePath, "set/b.png") {
+		t.Fatalf("refused resolutions dropped issues: %+v", app.GetScanIssues())
+	}
+
+	// 只移除报告不涉及文件，压缩包中也可以
+	issues, err := app.ResolveIssue(IssueOrphanCaption, filepath.Join(archivePath, "set", "orphan.txt"), ActionIgnore)
+	if err != nil || hasIssue(issues, IssueOrphanCaption, archivePath, "set/orphan.txt") {
+		t.Fatalf("ignore inside an archive: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// KeepTokensConfig declares the protected leading tags of the captions: the
+// trigger words kohya's keep_tokens pins while the rest of the tags are shuffled
+type KeepTokensConfig struct {
+	// Tokens are the leading tags of every caption, in order
+	Tokens []string `json:"tokens"`
+	// Folders overrides Tokens below a folder, keyed by its path relative to the
+	// dataset root ("10_mychar" or "chars/alice"); the deepest folder wins
+	Folders map[string][]string `json:"folders"`
+}
+
+func defaultKeepTokensConfig() KeepTokensConfig {
+	cfg := KeepTokensConfig{}
+	cfg.normalize()
+	return cfg
+}
+
+func (c *KeepTokensConfig) normalize() {
+	c.Tokens = normalizeKeepTokens(c.Tokens)
+	folders := make(map[string][]string, len(c.Folders))
+	for dir, tokens := range c.Folders {
+		dir = strings.Trim(filepath.ToSlash(strings.TrimSpace(dir)), "/")
+		folders[strings.TrimPrefix(dir, "./")] = normalizeKeepTokens(tokens)
+	}
+	c.Folders = folders
+}
+
+// normalizeKeepTokens trims the tokens and drops empty ones; nil becomes empty
+func normalizeKeepTokens(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+func (c *KeepTokensConfig) validate() error {
+	if err := validateKeepTokens(c.Tokens); err != nil {
+		return err
+	}
+	for dir, tokens := range c.Folders {
+		if dir == "" || dir == "." {
+			return fmt.Errorf("keep tokens folder must not be the dataset root, use tokens instead")
+		}
+		if err := validateKeepTokens(tokens); err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+func validateKeepTokens(tokens []string) error {
+	for i, token := range tokens {
+		if strings.ContainsAny(token, ",，\n") {
+			return fmt.Errorf("keep token must be a single tag: %q", token)
+		}
+		if containsString(tokens[:i], token) {
+			return fmt.Errorf("duplicate keep token: %s", token)
+		}
+	}
+	return nil
+}
+
+// tokensFor returns the keep tokens of the media file at path under root
+func (c KeepTokensConfig) tokensFor(root string, path string) []string {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return c.Tokens
+	}
+	rel = filepath.ToSlash(rel)
+	tokens, depth := c.Tokens, -1
+	for dir, t := range c.Folders {
+		if (rel == dir || strings.HasPrefix(rel, dir+"/")) && len(dir) > depth {
+			tokens, depth = t, len(dir)
+		}
+	}
+	return tokens
+}
+
+// keepTokens returns the keep tokens of item; the caller must hold a.mu. Natural
+// captions have no tag list to pin, so they have none.
+func (a *App) keepTokens(item DatasetItem) []string {
+	if a.config.Caption.Mode == CaptionModeNatural {
+		return nil
+	}
+	return a.config.KeepTokens.tokensFor(a.datasetPath, item.MediaPath)
+}
+
+// protectedTagCount returns how many leading tags are protected: the run of bare
+// tags at the start of the caption that are keep tokens
+func protectedTagCount(tags []string, keep []string) int {
+	n := 0
+	for n < len(tags) && containsString(keep, tags[n]) {
+		n++
+	}
+	return n
+}
+
+// keepInsertIndex returns where prepending tag lands: after the protected tags,
+// or, when tag is a keep token itself, before the protected tags that follow it
+// in keep
+func keepInsertIndex(tags []string, keep []string, tag string) int {
+	n := protectedTagCount(tags, keep)
+	k := indexOfString(keep, tag)
+	if k == -1 {
+		return n
+	}
+	for i := 0; i < n; i++ {
+		if indexOfString(keep, tags[i]) > k {
+			return i
+		}
+	}
+	return n
+}
+
+func indexOfString(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Keep token problems reported by GetKeepTokenIssues
+const (
+	// KeepIssueMissing: at least one keep token is not in the caption
+	KeepIssueMissing = "missing"
+	// KeepIssueMisplaced: all keep tokens are present but not as the leading tags in order
+	KeepIssueMisplaced = "misplaced"
+)
+
+// KeepTokenIssue is one caption whose leading tags are not its keep tokens
+type KeepTokenIssue struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Expected are the keep tokens, Leading the first tags the caption has instead
+	Expected []string `json:"expected"`
+	Leading  []string `json:"leading"`
+	// Missing lists the keep tokens not found anywhere in the caption
+	Missing []string `json:"missing,omitempty"`
+}
+
+// keepTokenIssue checks the bare tags of a caption against keep; ok is false
+// when the caption starts with the keep tokens in order
+func keepTokenIssue(tags []string, keep []string) (KeepTokenIssue, bool) {
+	leading := tags
+	if len(leading) > len(keep) {
+		leading = leading[:len(keep)]
+	}
+	issue := KeepTokenIssue{Expected: keep, Leading: append([]string{}, leading...)}
+	for _, token := range keep {
+		if !containsString(tags, token) {
+			issue.Missing = append(issue.Missing, token)
+		}
+	}
+	if len(issue.Missing) > 0 {
+		issue.Kind = KeepIssueMissing
+		return issue, true
+	}
+	for i, token := range keep {
+		if tags[i] != token {
+			issue.Kind = KeepIssueMisplaced
+			return issue, true
+		}
+	}
+	return issue, false
+}
+
+// applyKeepTokens moves the keep tokens to the start of the caption in order and
+// adds the missing ones. A token keeps its first written form (with its weight);
+// repeated occurrences further down are dropped.
+func applyKeepTokens(raw string, caption CaptionConfig, keep []string) string {
+	if _, bad := keepTokenIssue(captionTags(raw, caption), keep); !bad {
+		return raw
+	}
+	written := make(map[string]string, len(keep))
+	rest := editCaptionTags(raw, caption, func(text string) (string, bool) {
+		tag := bareTag(text)
+		if !containsString(keep, tag) {
+			return text, true
+		}
+		if _, ok := written[tag]; !ok {
+			written[tag] = text
+		}
+		return text, false
+	})
+	lead := make([]string, len(keep))
+	for i, token := range keep {
+		lead[i] = token
+		if text, ok := written[token]; ok {
+			lead[i] = text
+		}
+	}
+	return addCaptionTag(rest, caption, strings.Join(lead, ", "), "prepend")
+}
+
+// GetKeepTokenIssues lists the captions of itemIDs whose keep tokens are missing
+// or not at the start in order
+func (a *App) GetKeepTokenIssues(itemIDs []string) []KeepTokenIssue {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	issues := make([]KeepTokenIssue, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		keep := a.keepTokens(item)
+		if len(keep) == 0 {
+			continue
+		}
+		if issue, bad := keepTokenIssue(item.Tags, keep); bad {
+			issue.ID, issue.Path = item.ID, item.MediaPath
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// PreviewKeepTokens returns the captions of itemIDs that moving the keep tokens
+// to the front would change, without modifying anything
+func (a *App) PreviewKeepTokens(itemIDs []string) []CaptionChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	changes := make([]CaptionChange, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		keep := a.keepTokens(item)
+		if len(keep) == 0 {
+			continue
+		}
+		after := applyKeepTokens(item.RawTags, a.config.Caption, keep)
+		if after != item.RawTags {
+			changes = append(changes, CaptionChange{ID: item.ID, Path: item.MediaPath, Before: item.RawTags, After: after})
+		}
+	}
+	return changes
+}
+
+// BatchApplyKeepTokens moves the keep tokens of itemIDs to the front and adds the
+// missing ones; like the other batch operations the captions are written by
+// SaveAllChanges
+func (a *App) BatchApplyKeepTokens(itemIDs []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		keep := a.keepTokens(a.items[idx])
+		if len(keep) == 0 {
+			continue
+		}
+		newTags := applyKeepTokens(a.items[idx].RawTags, a.config.Caption, keep)
+		if newTags == a.items[idx].RawTags {
+			continue
+		}
+		a.setItemCaption(idx, newTags)
+		a.items[idx].Modified = true
+	}
+	return nil
+}
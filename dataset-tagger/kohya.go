@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kohyaFolderPattern matches kohya's "<repeats>_<concept>" training folders, e.g. 10_mychar
+var kohyaFolderPattern = regexp.MustCompile(`^(\d+)_(.+)$`)
+
+// ConceptInfo summarises one kohya repeat folder
+type ConceptInfo struct {
+	Dir             string `json:"dir"`
+	Concept         string `json:"concept"`
+	Repeats         int    `json:"repeats"`
+	Items           int    `json:"items"`
+	SamplesPerEpoch int    `json:"samplesPerEpoch"`
+}
+
+// parseKohyaFolder splits a folder name like "10_mychar" into repeats and concept
+func parseKohyaFolder(name string) (int, string, bool) {
+	m := kohyaFolderPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, "", false
+	}
+	repeats, err := strconv.Atoi(m[1])
+	if err != nil || repeats <= 0 {
+		return 0, "", false
+	}
+	return repeats, strings.TrimSpace(m[2]), true
+}
+
+// applyKohyaInfo fills Concept, ConceptDir and Repeats from the nearest repeat
+// folder between the media file and the dataset root
+func applyKohyaInfo(root string, item *DatasetItem) {
+	item.Concept, item.ConceptDir, item.Repeats = "", "", 0
+	dir := filepath.Dir(item.MediaPath)
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+		if repeats, concept, ok := parseKohyaFolder(filepath.Base(dir)); ok {
+			item.Concept = concept
+			item.ConceptDir = dir
+			item.Repeats = repeats
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// conceptStats groups items by repeat folder; items outside such folders are skipped
+func conceptStats(items []DatasetItem) []ConceptInfo {
+	byDir := make(map[string]*ConceptInfo)
+	for _, item := range items {
+		if item.ConceptDir == "" {
+			continue
+		}
+		info, ok := byDir[item.ConceptDir]
+		if !ok {
+			info = &ConceptInfo{Dir: item.ConceptDir, Concept: item.Concept, Repeats: item.Repeats}
+			byDir[item.ConceptDir] = info
+		}
+		info.Items++
+		info.SamplesPerEpoch += item.Repeats
+	}
+
+	result := make([]ConceptInfo, 0, len(byDir))
+	for _, info := range byDir {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return naturalLess(result[i].Dir, result[j].Dir)
+	})
+	return result
+}
+
+// GetConceptStats returns per-folder item counts and samples per epoch (items × repeats)
+func (a *App) GetConceptStats() []ConceptInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return conceptStats(a.items)
+}
+
+// BatchAddConceptTag adds the concept name of a repeat folder as a trigger tag to
+// every item in that folder that does not already have it
+func (a *App) BatchAddConceptTag(conceptDir string, position string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	concept := ""
+	ids := make([]string, 0)
+	for _, item := range a.items {
+		if item.ConceptDir != conceptDir {
+			continue
+		}
+		concept = item.Concept
+		if !containsString(item.Tags, item.Concept) {
+			ids = append(ids, item.ID)
+		}
+	}
+	if concept == "" {
+		return 0, fmt.Errorf("no items in concept folder: %s", conceptDir)
+	}
+	return len(ids), a.batchAddTag(ids, concept, position)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
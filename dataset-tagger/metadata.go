@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyMediaInfo fills size, mtime, format and, for images, the dimensions of item.
+// Only the image header is decoded, so this is cheap even for large files.
+func applyMediaInfo(src datasetSource, item *DatasetItem) {
+	item.Width, item.Height, item.Aspect, item.Megapixels = 0, 0, 0, 0
+	item.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(item.MediaPath)), ".")
+
+	info, err := src.Stat(item.MediaPath)
+	if err != nil {
+		return
+	}
+	item.Bytes = info.Size()
+	item.ModTime = info.ModTime().UnixMilli()
+	if item.IsVideo {
+		return
+	}
+
+	f, err := src.Open(item.MediaPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return
+	}
+	// 以文件内容识别的格式为准，扩展名可能与实际格式不符
+	item.Format = format
+	item.Width = cfg.Width
+	item.Height = cfg.Height
+	item.Aspect = math.Round(float64(cfg.Width)/float64(cfg.Height)*1000) / 1000
+	item.Megapixels = math.Round(float64(cfg.Width)*float64(cfg.Height)/1e4) / 100
+}
+
+// Fields accepted in filter conditions; the tag categories of dictionary.go
+// (character, general, ...) are accepted too and compare the item's tag count
+const (
+	FieldWidth      = "width"
+	FieldHeight     = "height"
+	FieldAspect     = "aspect"
+	FieldMegapixels = "megapixels"
+	FieldBytes      = "bytes"
+	FieldModTime    = "mtime"
+	FieldFormat     = "format"
+	FieldTagCount   = "tags"
+)
+
+// filterFieldAliases maps shorthand names to filter fields
+var filterFieldAliases = map[string]string{
+	"w":    FieldWidth,
+	"h":    FieldHeight,
+	"mp":   FieldMegapixels,
+	"size": FieldBytes,
+}
+
+// filterCondition is one parsed "field op value" term
+type filterCondition struct {
+	field  string
+	op     string
+	number float64
+	text   string
+}
+
+var (
+	conditionPattern = regexp.MustCompile(`([A-Za-z_]+)\s*(<=|>=|!=|==|=|<|>)\s*([^\s,]+)`)
+	// 条件之间可以用空格、逗号、and 或 && 分隔
+	conditionSeparator = regexp.MustCompile(`(?i)^(\s|,|&&|\band\b)*$`)
+	byteSizePattern    = regexp.MustCompile(`(?i)^([0-9.]+)\s*(b|k|kb|m|mb|g|gb)?$`)
+)
+
+// parseFilterConditions parses expressions such as "width<1024 aspect>2"; all
+// conditions must hold for an item to match
+func parseFilterConditions(expr string) ([]filterCondition, error) {
+	conds := make([]filterCondition, 0)
+	matches := conditionPattern.FindAllStringSubmatchIndex(expr, -1)
+	last := 0
+	for _, m := range matches {
+		if !conditionSeparator.MatchString(expr[last:m[0]]) {
+			return nil, fmt.Errorf("invalid condition near %q", strings.TrimSpace(expr[last:m[0]]))
+		}
+		last = m[1]
+
+		field := strings.ToLower(expr[m[2]:m[3]])
+		if alias, ok := filterFieldAliases[field]; ok {
+			field = alias
+		}
+		op := expr[m[4]:m[5]]
+		if op == "==" {
+			op = "="
+		}
+		cond, err := parseConditionValue(field, op, expr[m[6]:m[7]])
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	if !conditionSeparator.MatchString(expr[last:]) {
+		return nil, fmt.Errorf("invalid condition near %q", strings.TrimSpace(expr[last:]))
+	}
+	return conds, nil
+}
+
+func parseConditionValue(field, op, value string) (filterCondition, error) {
+	cond := filterCondition{field: field, op: op}
+	var err error
+	switch field {
+	case FieldFormat:
+		if op != "=" && op != "!=" {
+			return cond, fmt.Errorf("format only supports = and !=")
+		}
+		cond.text = strings.TrimPrefix(strings.ToLower(value), ".")
+		if cond.text == "jpg" {
+			cond.text = "jpeg"
+		}
+	case FieldWidth, FieldHeight, FieldMegapixels, FieldTagCount,
+		CategoryCharacter, CategoryCopyright, CategoryArtist, CategoryGeneral, CategoryMeta:
+		cond.number, err = strconv.ParseFloat(value, 64)
+	case FieldAspect:
+		// 支持 16:9 这种写法
+		if w, h, ok := strings.Cut(value, ":"); ok {
+			var fw, fh float64
+			fw, err = strconv.ParseFloat(w, 64)
+			if err == nil {
+				fh, err = strconv.ParseFloat(h, 64)
+			}
+			if err == nil && fh == 0 {
+				err = fmt.Errorf("zero height")
+			}
+			if err == nil {
+				cond.number = fw / fh
+			}
+		} else {
+			cond.number, err = strconv.ParseFloat(value, 64)
+		}
+	case FieldBytes:
+		cond.number, err = parseByteSize(value)
+	case FieldModTime:
+		cond.number, err = parseFilterTime(value)
+	default:
+		return cond, fmt.Errorf("unknown filter field: %s", field)
+	}
+	if err != nil {
+		return cond, fmt.Errorf("invalid value for %s: %s", field, value)
+	}
+	return cond, nil
+}
+
+// parseByteSize accepts plain bytes or sizes such as 500k, 2MB (1024-based)
+func parseByteSize(value string) (float64, error) {
+	m := byteSizePattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size")
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToLower(m[2]) {
+	case "k", "kb":
+		n *= 1 << 10
+	case "m", "mb":
+		n *= 1 << 20
+	case "g", "gb":
+		n *= 1 << 30
+	}
+	return n, nil
+}
+
+// parseFilterTime accepts a date (2006-01-02, local time), RFC 3339 or unix seconds
+// and returns unix milliseconds like DatasetItem.ModTime
+func parseFilterTime(value string) (float64, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return float64(t.UnixMilli()), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return float64(t.UnixMilli()), nil
+	}
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(secs * 1000), nil
+}
+
+// matches reports whether item satisfies the condition. Items without known
+// dimensions (videos, unreadable images) never match dimension conditions.
+// categories holds the item's tag count per category for category fields.
+func (c filterCondition) matches(item DatasetItem, categories map[string]int) bool {
+	var value float64
+	switch c.field {
+	case FieldFormat:
+		return (item.Format == c.text) == (c.op == "=")
+	case FieldWidth, FieldHeight, FieldAspect, FieldMegapixels:
+		if item.Width == 0 {
+			return false
+		}
+		value = map[string]float64{
+			FieldWidth:      float64(item.Width),
+			FieldHeight:     float64(item.Height),
+			FieldAspect:     float64(item.Width) / float64(item.Height),
+			FieldMegapixels: float64(item.Width) * float64(item.Height) / 1e6,
+		}[c.field]
+	case FieldBytes:
+		value = float64(item.Bytes)
+	case FieldModTime:
+		value = float64(item.ModTime)
+	case FieldTagCount:
+		value = float64(len(item.Tags))
+	case CategoryCharacter, CategoryCopyright, CategoryArtist, CategoryGeneral, CategoryMeta:
+		value = float64(categories[c.field])
+	}
+
+	switch c.op {
+	case "<":
+		return value < c.number
+	case "<=":
+		return value <= c.number
+	case ">":
+		return value > c.number
+	case ">=":
+		return value >= c.number
+	case "!=":
+		return value != c.number
+	}
+	return value == c.number
+}
+
+func matchesAll(conds []filterCondition, item DatasetItem, categories map[string]int) bool {
+	for _, c := range conds {
+		if !c.matches(item, categories) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterItems returns the items whose caption contains phrase (empty matches all)
+// and that satisfy every condition in where, e.g. "width<1024 aspect>2".
+// Fields: width, height, aspect (also 16:9), megapixels (mp), bytes (size, 2MB),
+// mtime (2024-01-31), format (png), tags (tag count) and the tag count of a
+// dictionary category: character, copyright, artist, general and meta.
+func (a *App) FilterItems(phrase string, where string) ([]DatasetItem, error) {
+	conds, err := parseFilterConditions(where)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// 只有用到分类条件时才查词典
+	byCategory := false
+	for _, c := range conds {
+		byCategory = byCategory || containsString(tagCategories, c.field)
+	}
+	rules := a.tagRules()
+
+	result := make([]DatasetItem, 0)
+	for _, item := range a.items {
+		if phrase != "" && !strings.Contains(item.RawTags, phrase) {
+			continue
+		}
+		var categories map[string]int
+		if byCategory {
+			categories = a.itemCategoryCounts(item, rules)
+		}
+		if matchesAll(conds, item, categories) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
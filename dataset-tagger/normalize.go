@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization steps, applied to every tag in the order they are listed
+const (
+	// NormalizeUnderscores converts between "blue_eyes" and "blue eyes"
+	NormalizeUnderscores = "underscores"
+	// NormalizeCaseFold folds case so "Blue Eyes" becomes "blue eyes"
+	NormalizeCaseFold = "casefold"
+	// NormalizeNFKC applies Unicode NFKC normalization
+	NormalizeNFKC = "nfkc"
+	// NormalizeFullwidth converts fullwidth punctuation and the "，" separator to ASCII
+	NormalizeFullwidth = "fullwidth"
+	// NormalizeEscapeParens escapes parentheses for SD prompt syntax: "fate (series)" -> "fate \(series\)".
+	// It always runs last, when the tag is written back with its emphasis.
+	NormalizeEscapeParens = "escape_parens"
+	// NormalizeDedupe drops tags that repeat an earlier tag of the same caption
+	NormalizeDedupe = "dedupe"
+)
+
+// normalizeSteps lists every step in the default pipeline order
+var normalizeSteps = []string{
+	NormalizeNFKC,
+	NormalizeFullwidth,
+	NormalizeUnderscores,
+	NormalizeCaseFold,
+	NormalizeEscapeParens,
+	NormalizeDedupe,
+}
+
+// Directions of the underscores step
+const (
+	UnderscoresToSpace      = "space"
+	UnderscoresToUnderscore = "underscore"
+)
+
+// NormalizeConfig describes the tag normalization pipeline of a dataset
+type NormalizeConfig struct {
+	// Steps run in order; nil means the default pipeline without escape_parens
+	Steps []string `json:"steps"`
+	// Underscores is the direction of the underscores step
+	Underscores string `json:"underscores"`
+	// Weights is what happens to SD emphasis such as "(tag:1.2)": keep, explicit or strip
+	Weights string `json:"weights"`
+}
+
+func defaultNormalizeConfig() NormalizeConfig {
+	cfg := NormalizeConfig{}
+	cfg.normalize()
+	return cfg
+}
+
+func (c *NormalizeConfig) normalize() {
+	if c.Steps == nil {
+		// 默认不转义括号：多数数据集把括号当作权重语法
+		c.Steps = make([]string, 0, len(normalizeSteps))
+		for _, step := range normalizeSteps {
+			if step != NormalizeEscapeParens {
+				c.Steps = append(c.Steps, step)
+			}
+		}
+	}
+	for i, step := range c.Steps {
+		c.Steps[i] = strings.ToLower(strings.TrimSpace(step))
+	}
+	c.Underscores = strings.ToLower(strings.TrimSpace(c.Underscores))
+	if c.Underscores == "" {
+		c.Underscores = UnderscoresToSpace
+	}
+	c.Weights = strings.ToLower(strings.TrimSpace(c.Weights))
+	if c.Weights == "" {
+		c.Weights = WeightKeep
+	}
+}
+
+func (c *NormalizeConfig) validate() error {
+	for _, step := range c.Steps {
+		if !containsString(normalizeSteps, step) {
+			return fmt.Errorf("unknown normalization step: %s", step)
+		}
+	}
+	switch c.Underscores {
+	case UnderscoresToSpace, UnderscoresToUnderscore:
+	default:
+		return fmt.Errorf("unknown underscores direction: %s", c.Underscores)
+	}
+	return validateWeightMode(c.Weights)
+}
+
+func (c NormalizeConfig) has(step string) bool {
+	return containsString(c.Steps, step)
+}
+
+// normalizeTag runs one tag as written in a caption through the pipeline. The
+// steps apply to the bare tag, which is then written back with its emphasis
+// according to cfg.Weights.
+func normalizeTag(text string, cfg NormalizeConfig) string {
+	p := parsePromptTag(text)
+	return p.render(normalizeBareTag(p.Tag, cfg), cfg.Weights, cfg.has(NormalizeEscapeParens))
+}
+
+// normalizeBareTag runs a bare tag through the pipeline; dedupe is handled per
+// caption and escaping when the tag is written back
+func normalizeBareTag(tag string, cfg NormalizeConfig) string {
+	for _, step := range cfg.Steps {
+		switch step {
+		case NormalizeUnderscores:
+			tag = convertUnderscores(tag, cfg.Underscores)
+		case NormalizeCaseFold:
+			tag = cases.Fold().String(tag)
+		case NormalizeNFKC:
+			tag = norm.NFKC.String(tag)
+		case NormalizeFullwidth:
+			tag = strings.Map(fullwidthToASCII, tag)
+		}
+	}
+	// 多余的空白总是合并，"blue  eyes " 与 "blue eyes" 视为同一个标签
+	return strings.Join(strings.Fields(tag), " ")
+}
+
+// convertUnderscores swaps underscores and spaces between words. Short tags such
+// as "o_o" or "^_^" are emoticons and are left alone.
+func convertUnderscores(tag string, direction string) string {
+	if len([]rune(tag)) <= 3 {
+		return tag
+	}
+	from, to := '_', ' '
+	if direction == UnderscoresToUnderscore {
+		from, to = ' ', '_'
+	}
+	runes := []rune(strings.TrimSpace(tag))
+	for i := 1; i < len(runes)-1; i++ {
+		if runes[i] == from && isWordRune(runes[i-1]) && isWordRune(runes[i+1]) {
+			runes[i] = to
+		}
+	}
+	return string(runes)
+}
+
+// isWordRune also accepts parentheses so "fate_(series)" is converted too
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == ')' || r == '('
+}
+
+// fullwidthToASCII maps fullwidth forms (U+FF01-U+FF5E) and the ideographic space
+// to their ASCII counterparts
+func fullwidthToASCII(r rune) rune {
+	switch {
+	case r >= 0xFF01 && r <= 0xFF5E:
+		return r - 0xFEE0
+	case r == 0x3000:
+		return ' '
+	}
+	return r
+}
+
+// escapeParens escapes parentheses that are not escaped yet
+func escapeParens(tag string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range tag {
+		if (r == '(' || r == ')') && !escaped {
+			b.WriteByte('\\')
+		}
+		escaped = r == '\\' && !escaped
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// normalizeCaption normalizes every tag of the caption in place. Only changed
+// tags and, with the fullwidth step, "，" separators are rewritten; prose lines
+// are left as they are.
+func normalizeCaption(raw string, caption CaptionConfig, cfg NormalizeConfig) string {
+	seen := make(map[string]bool)
+	raw = editCaptionTags(raw, caption, func(text string) (string, bool) {
+		normalized := normalizeTag(text, cfg)
+		if cfg.has(NormalizeDedupe) {
+			// 按不带权重的标签去重，保留第一次出现的写法
+			bare := bareTag(normalized)
+			if seen[bare] {
+				return normalized, false
+			}
+			seen[bare] = true
+		}
+		return normalized, true
+	})
+	if !cfg.has(NormalizeFullwidth) {
+		return raw
+	}
+
+	edits := make([]spanEdit, 0)
+	for _, line := range splitCaptionLines(raw, caption) {
+		if !line.tags {
+			continue
+		}
+		for i := 0; i < len(line.text); {
+			j := strings.Index(line.text[i:], "，")
+			if j == -1 {
+				break
+			}
+			i += j + len("，")
+			sep := ","
+			if i < len(line.text) && line.text[i] != ' ' {
+				sep = ", "
+			}
+			edits = append(edits, spanEdit{start: line.start + i - len("，"), end: line.start + i, text: sep})
+		}
+	}
+	return applySpanEdits(raw, edits)
+}
+
+// CaptionChange is the before/after of one caption in a preview or in the
+// output of a batch subcommand
+type CaptionChange struct {
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// PreviewNormalize returns the captions of itemIDs that the dataset's
+// normalization pipeline would change, without modifying anything
+func (a *App) PreviewNormalize(itemIDs []string) []CaptionChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	changes := make([]CaptionChange, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		after := normalizeCaption(item.RawTags, a.config.Caption, a.config.Normalize)
+		if after != item.RawTags {
+			changes = append(changes, CaptionChange{ID: item.ID, Path: item.MediaPath, Before: item.RawTags, After: after})
+		}
+	}
+	return changes
+}
+
+// BatchNormalizeTags applies the normalization pipeline to itemIDs; like the other
+// batch operations the captions are written by SaveAllChanges
+func (a *App) BatchNormalizeTags(itemIDs []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		newTags := normalizeCaption(a.items[idx].RawTags, a.config.Caption, a.config.Normalize)
+		if newTags == a.items[idx].RawTags {
+			continue
+		}
+		a.setItemCaption(idx, newTags)
+		a.items[idx].Modified = true
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PairingConfig controls how media files are matched with caption files
+type PairingConfig struct {
+	// CaptionExts are caption extensions in priority order, e.g. [".txt", ".caption"].
+	// A media file is paired with the first one that exists.
+	CaptionExts []string `json:"captionExts"`
+	// PrimaryCaption is the extension edited and written by the app; defaults to CaptionExts[0]
+	PrimaryCaption string `json:"primaryCaption"`
+	// SidecarExts are extra files attached to a media file that are not captions, e.g. [".json"]
+	SidecarExts []string `json:"sidecarExts"`
+	// ExtraImageExts / ExtraVideoExts extend the built-in media extensions
+	ExtraImageExts []string `json:"extraImageExts"`
+	ExtraVideoExts []string `json:"extraVideoExts"`
+	// CaptionDirs maps media directories to parallel caption directories
+	CaptionDirs []CaptionDirMapping `json:"captionDirs"`
+}
+
+// CaptionDirMapping stores captions for media under Media in a parallel tree under
+// Caption, both relative to the dataset root: images/a/x.png <-> captions/a/x.txt
+type CaptionDirMapping struct {
+	Media   string `json:"media"`
+	Caption string `json:"caption"`
+}
+
+func defaultPairingConfig() PairingConfig {
+	return PairingConfig{
+		CaptionExts:    []string{".txt"},
+		PrimaryCaption: ".txt",
+		SidecarExts:    []string{},
+		ExtraImageExts: []string{},
+		ExtraVideoExts: []string{},
+		CaptionDirs:    []CaptionDirMapping{},
+	}
+}
+
+// normalize lowercases extensions, adds missing dots and fills defaults
+func (c *PairingConfig) normalize() {
+	c.CaptionExts = normalizeExts(c.CaptionExts)
+	c.SidecarExts = normalizeExts(c.SidecarExts)
+	c.ExtraImageExts = normalizeExts(c.ExtraImageExts)
+	c.ExtraVideoExts = normalizeExts(c.ExtraVideoExts)
+	if len(c.CaptionExts) == 0 {
+		c.CaptionExts = []string{".txt"}
+	}
+	if c.PrimaryCaption == "" {
+		c.PrimaryCaption = c.CaptionExts[0]
+	} else {
+		c.PrimaryCaption = normalizeExts([]string{c.PrimaryCaption})[0]
+	}
+	if c.CaptionDirs == nil {
+		c.CaptionDirs = []CaptionDirMapping{}
+	}
+	for i, m := range c.CaptionDirs {
+		c.CaptionDirs[i].Media = cleanRelDir(m.Media)
+		c.CaptionDirs[i].Caption = cleanRelDir(m.Caption)
+	}
+}
+
+// validate rejects configurations that would make pairing ambiguous
+func (c *PairingConfig) validate() error {
+	seen := make(map[string]string)
+	add := func(exts []string, role string) error {
+		for _, ext := range exts {
+			if prev, ok := seen[ext]; ok && prev != role {
+				return fmt.Errorf("extension %s is used as both %s and %s", ext, prev, role)
+			}
+			seen[ext] = role
+		}
+		return nil
+	}
+	if err := add(c.CaptionExts, "caption"); err != nil {
+		return err
+	}
+	if err := add(c.SidecarExts, "sidecar"); err != nil {
+		return err
+	}
+	builtin := make([]string, 0, len(imageExts)+len(videoExts))
+	for ext := range imageExts {
+		builtin = append(builtin, ext)
+	}
+	for ext := range videoExts {
+		builtin = append(builtin, ext)
+	}
+	if err := add(append(append(builtin, c.ExtraImageExts...), c.ExtraVideoExts...), "media"); err != nil {
+		return err
+	}
+
+	primaryOK := false
+	for _, ext := range c.CaptionExts {
+		if ext == c.PrimaryCaption {
+			primaryOK = true
+		}
+	}
+	if !primaryOK {
+		return fmt.Errorf("primary caption %s is not one of the caption extensions", c.PrimaryCaption)
+	}
+	for _, m := range c.CaptionDirs {
+		if m.Caption == m.Media {
+			return fmt.Errorf("caption directory %q must differ from its media directory", m.Caption)
+		}
+	}
+	return nil
+}
+
+func normalizeExts(exts []string) []string {
+	out := make([]string, 0, len(exts))
+	seen := make(map[string]bool)
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if !seen[ext] {
+			seen[ext] = true
+			out = append(out, ext)
+		}
+	}
+	return out
+}
+
+func cleanRelDir(dir string) string {
+	dir = filepath.Clean(filepath.FromSlash(strings.TrimSpace(dir)))
+	if dir == "." {
+		return ""
+	}
+	return strings.Trim(dir, string(filepath.Separator))
+}
+
+// File roles assigned by pairer.classify
+const (
+	fileOther = iota
+	fileImage
+	fileVideo
+	fileCaption
+	fileSidecar
+)
+
+// pairer applies a PairingConfig to paths under one dataset root
+type pairer struct {
+	root       string
+	cfg        PairingConfig
+	images     map[string]bool
+	videos     map[string]bool
+	captionIdx map[string]int
+	sidecars   map[string]bool
+	// ignored holds the files the dataset config points to, such as stopword
+	// lists, which are never paired even when they have a caption extension
+	ignored map[string]bool
+}
+
+func newPairer(root string, cfg PairingConfig) *pairer {
+	p := &pairer{
+		root:       root,
+		cfg:        cfg,
+		images:     make(map[string]bool),
+		videos:     make(map[string]bool),
+		captionIdx: make(map[string]int),
+		sidecars:   make(map[string]bool),
+	}
+	for ext := range imageExts {
+		p.images[ext] = true
+	}
+	for ext := range videoExts {
+		p.videos[ext] = true
+	}
+	for _, ext := range cfg.ExtraImageExts {
+		p.images[ext] = true
+	}
+	for _, ext := range cfg.ExtraVideoExts {
+		p.videos[ext] = true
+	}
+	for i, ext := range cfg.CaptionExts {
+		p.captionIdx[ext] = i
+	}
+	for _, ext := range cfg.SidecarExts {
+		p.sidecars[ext] = true
+	}
+	return p
+}
+
+// ignoring returns a copy of p whose classify treats paths as plain files; p is
+// left alone since a running watcher may be using it
+func (p *pairer) ignoring(paths []string) *pairer {
+	c := *p
+	c.ignored = make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if path != "" {
+			c.ignored[filepath.Clean(path)] = true
+		}
+	}
+	return &c
+}
+
+// classify returns the role of a file and its pairing key (media path without extension)
+func (p *pairer) classify(path string) (int, string) {
+	if filepath.Base(path) == datasetConfigFile || p.ignored[filepath.Clean(path)] {
+		return fileOther, ""
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case p.images[ext]:
+		return fileImage, mediaKey(path)
+	case p.videos[ext]:
+		return fileVideo, mediaKey(path)
+	}
+	if _, ok := p.captionIdx[ext]; ok {
+		return fileCaption, p.mediaSideKey(path)
+	}
+	if p.sidecars[ext] {
+		return fileSidecar, p.mediaSideKey(path)
+	}
+	return fileOther, ""
+}
+
+func (p *pairer) isVideo(path string) bool {
+	return p.videos[strings.ToLower(filepath.Ext(path))]
+}
+
+// mediaSideKey maps a caption or sidecar path back to the key of its media file
+func (p *pairer) mediaSideKey(path string) string {
+	key := mediaKey(path)
+	if rel, ok := p.relPath(key); ok {
+		for _, m := range p.cfg.CaptionDirs {
+			if rest, ok := trimDirPrefix(rel, m.Caption); ok {
+				return filepath.Join(p.root, m.Media, rest)
+			}
+		}
+	}
+	return key
+}
+
+// captionKey maps a media key to the key its captions are stored under
+func (p *pairer) captionKey(key string) string {
+	if rel, ok := p.relPath(key); ok {
+		for _, m := range p.cfg.CaptionDirs {
+			if rest, ok := trimDirPrefix(rel, m.Media); ok {
+				return filepath.Join(p.root, m.Caption, rest)
+			}
+		}
+	}
+	return key
+}
+
+// captionPath is where the primary caption of a media file is written
+func (p *pairer) captionPath(mediaPath string) string {
+	return p.captionKey(mediaKey(mediaPath)) + p.cfg.PrimaryCaption
+}
+
+// captionCandidates lists possible caption paths of a media file, best first
+func (p *pairer) captionCandidates(mediaPath string) []string {
+	key := p.captionKey(mediaKey(mediaPath))
+	paths := []string{key + p.cfg.PrimaryCaption}
+	for _, ext := range p.cfg.CaptionExts {
+		if ext != p.cfg.PrimaryCaption {
+			paths = append(paths, key+ext)
+		}
+	}
+	return paths
+}
+
+// pickCaption chooses the caption to edit among the files found for one media key
+// and returns the remaining caption and sidecar files as sidecars
+func (p *pairer) pickCaption(files []string) (string, []string) {
+	if len(files) == 0 {
+		return "", nil
+	}
+	sorted := append([]string(nil), files...)
+	rank := func(path string) int {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == p.cfg.PrimaryCaption {
+			return -1
+		}
+		if i, ok := p.captionIdx[ext]; ok {
+			return i
+		}
+		return len(p.captionIdx) // sidecar
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := rank(sorted[i]), rank(sorted[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	if rank(sorted[0]) == len(p.captionIdx) {
+		return "", sorted
+	}
+	return sorted[0], sorted[1:]
+}
+
+func (p *pairer) relPath(path string) (string, bool) {
+	rel, err := filepath.Rel(p.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// trimDirPrefix strips dir from rel when rel lies inside it ("" matches everything)
+func trimDirPrefix(rel, dir string) (string, bool) {
+	if dir == "" {
+		return rel, true
+	}
+	if strings.HasPrefix(rel, dir+string(filepath.Separator)) {
+		return rel[len(dir)+1:], true
+	}
+	return "", false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PhraseConfig tunes the common phrase statistics. It is stored with the dataset
+// so every teammate sees the same phrases; zero fields take the defaults, except
+// MaxDocRatio, which defaults to 1 only when it is left out.
+type PhraseConfig struct {
+	// Mode is PhraseModeAuto, PhraseModeChars or PhraseModeWords
+	Mode string `json:"mode"`
+	// MinChars and MaxChars bound the phrases counted by runes
+	MinChars int `json:"minChars"`
+	MaxChars int `json:"maxChars"`
+	// MinWords and MaxWords bound the phrases counted by words
+	MinWords int `json:"minWords"`
+	MaxWords int `json:"maxWords"`
+	// MinDocs is the number of captions a phrase must occur in
+	MinDocs int `json:"minDocs"`
+	// MaxDocRatio drops phrases found in more than this share of the captions,
+	// such as a trigger word every caption starts with; 1 keeps them all and 0,
+	// which would list nothing, is rejected
+	MaxDocRatio float64 `json:"maxDocRatio"`
+	// Limit is the number of phrases listed
+	Limit int `json:"limit"`
+	// Stopwords and Blocklist are text files with one entry per line; relative
+	// paths are resolved against the dataset root. Phrases starting or ending
+	// with a stopword are not listed, blocked phrases never are.
+	Stopwords string `json:"stopwords"`
+	Blocklist string `json:"blocklist"`
+}
+
+// maxPhraseChars and maxPhraseWords cap the phrase lengths, which the indexes
+// are built for
+const (
+	maxPhraseChars = 64
+	maxPhraseWords = 10
+)
+
+func defaultPhraseConfig() PhraseConfig {
+	cfg := PhraseConfig{MaxDocRatio: 1}
+	cfg.normalize()
+	return cfg
+}
+
+// UnmarshalJSON fills in MaxDocRatio when the JSON leaves it out, so that an
+// explicit 0 can be told apart and rejected by validate
+func (c *PhraseConfig) UnmarshalJSON(data []byte) error {
+	type plain PhraseConfig
+	p := plain{MaxDocRatio: 1}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*c = PhraseConfig(p)
+	return nil
+}
+
+func (c *PhraseConfig) normalize() {
+	c.Mode = strings.ToLower(strings.TrimSpace(c.Mode))
+	if c.Mode == "" {
+		c.Mode = PhraseModeAuto
+	}
+	defaults := []struct {
+		field *int
+		value int
+	}{
+		{&c.MinChars, 2}, {&c.MaxChars, 15}, {&c.MinWords, 1}, {&c.MaxWords, 5}, {&c.MinDocs, 2}, {&c.Limit, 100},
+	}
+	for _, d := range defaults {
+		if *d.field == 0 {
+			*d.field = d.value
+		}
+	}
+	c.Stopwords = strings.TrimSpace(c.Stopwords)
+	c.Blocklist = strings.TrimSpace(c.Blocklist)
+}
+
+func (c *PhraseConfig) validate() error {
+	if err := validatePhraseMode(c.Mode); err != nil {
+		return err
+	}
+	if c.MinChars < 1 || c.MinChars > c.MaxChars || c.MaxChars > maxPhraseChars {
+		return fmt.Errorf("phrase chars must satisfy 1 <= min <= max <= %d", maxPhraseChars)
+	}
+	if c.MinWords < 1 || c.MinWords > c.MaxWords || c.MaxWords > maxPhraseWords {
+		return fmt.Errorf("phrase words must satisfy 1 <= min <= max <= %d", maxPhraseWords)
+	}
+	if c.MinDocs < 1 {
+		return fmt.Errorf("phrase minDocs must be at least 1")
+	}
+	if c.MaxDocRatio <= 0 || c.MaxDocRatio > 1 {
+		return fmt.Errorf("phrase maxDocRatio must be greater than 0 and at most 1")
+	}
+	if c.Limit < 1 {
+		return fmt.Errorf("phrase limit must be at least 1")
+	}
+	return nil
+}
+
+// files lists the stopwords and blocklist files, resolved against root
+func (c PhraseConfig) files(root string) []string {
+	return []string{configFilePath(root, c.Stopwords), configFilePath(root, c.Blocklist)}
+}
+
+// phraseFilter holds the stopwords and the blocklist of a dataset, lower-case
+type phraseFilter struct {
+	stopwords map[string]bool
+	blocked   map[string]bool
+}
+
+// isStopword reports whether word may not start or end a word phrase
+func (f phraseFilter) isStopword(word string) bool {
+	return phraseStopwords[word] || f.stopwords[word]
+}
+
+// allows reports whether a rune phrase may be listed. The built-in stopwords are
+// words, so only the dataset's own stopwords apply to rune phrases.
+func (f phraseFilter) allows(text string) bool {
+	text = strings.ToLower(text)
+	if f.blocked[text] {
+		return false
+	}
+	for w := range f.stopwords {
+		if strings.HasPrefix(text, w) || strings.HasSuffix(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPhraseFilter reads the stopwords and blocklist files of cfg
+func loadPhraseFilter(src datasetSource, root string, cfg PhraseConfig) (phraseFilter, error) {
+	f := phraseFilter{stopwords: make(map[string]bool), blocked: make(map[string]bool)}
+	for _, list := range []struct {
+		path string
+		set  map[string]bool
+	}{{cfg.Stopwords, f.stopwords}, {cfg.Blocklist, f.blocked}} {
+		path := configFilePath(root, list.path)
+		if path == "" {
+			continue
+		}
+		data, err := readConfigFile(src, root, path)
+		if err != nil {
+			return f, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+			if line != "" && !strings.HasPrefix(line, "#") {
+				list.set[strings.ToLower(line)] = true
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return f, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+	return f, nil
+}
+
+// usePhraseConfig makes cfg the phrase options of analyzeCommonPhrases and
+// loads its stopwords and blocklist, without saving them; the caller must hold
+// a.mu
+func (a *App) usePhraseConfig(cfg PhraseConfig) error {
+	if a.datasetPath == "" {
+		return fmt.Errorf("no dataset folder loaded")
+	}
+	filter, err := loadPhraseFilter(a.source, a.datasetPath, cfg)
+	if err != nil {
+		return err
+	}
+	a.phraseConfig = cfg
+	a.phraseFilter = filter
+	if a.ignoreConfigFiles() && a.watcher != nil {
+		a.startWatcher(a.watcher.interval)
+	}
+	return nil
+}
+
+// SavePhraseConfig validates opts, writes them to the dataset config and makes
+// them the phrase options in use, so teammates opening the dataset see the same
+// statistics; zero fields take the defaults
+func (a *App) SavePhraseConfig(opts PhraseConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.datasetPath == "" {
+		return fmt.Errorf("no dataset folder loaded")
+	}
+	opts.normalize()
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	filter, err := loadPhraseFilter(a.source, a.datasetPath, opts)
+	if err != nil {
+		return err
+	}
+	config := a.config
+	config.Phrases = opts
+	data, err := encodeDatasetConfig(config)
+	if err != nil {
+		return err
+	}
+	if _, err := a.prepareWrite(false); err != nil {
+		return err
+	}
+	if err := a.writeDatasetFile(filepath.Join(a.datasetPath, datasetConfigFile), data); err != nil {
+		return err
+	}
+	a.config = config
+	a.phraseConfig = opts
+	a.phraseFilter = filter
+	if a.ignoreConfigFiles() && a.watcher != nil {
+		a.startWatcher(a.watcher.interval)
+	}
+	return a.flushArchive()
+}
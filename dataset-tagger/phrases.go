@@ -0,0 +1,331 @@
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// phraseIndex counts the common phrases of the captions. It is a generalized
+// suffix automaton over the caption segments: every state stands for a set of
+// substrings that always occur together, so the index stays linear in the
+// caption text instead of storing every substring. Captions are tracked by
+// item ID; a changed caption is uncounted and counted again without touching
+// the others.
+type phraseIndex struct {
+	// maxLen is the longest phrase counted, in runes
+	maxLen int32
+	states []phraseState
+	// root has an edge for every distinct rune, so it gets a map
+	root     map[rune]int32
+	segments [][]rune
+	docs     map[string]string
+	// stamp marks the states already counted for the caption being counted
+	stamp int32
+	// live and dead count the runes of indexed and of replaced captions; the
+	// states of replaced captions stay, so the index is rebuilt once they dominate
+	live, dead int
+}
+
+// phraseState is one state of the automaton: the substrings of lengths
+// (len(link), len] ending at the same positions
+type phraseState struct {
+	len  int32
+	link int32
+	next []phraseEdge
+	// docs is the number of captions containing these substrings
+	docs int32
+	seen int32
+	// seg and end locate one occurrence, to spell the substrings out
+	seg, end int32
+}
+
+type phraseEdge struct {
+	r  rune
+	to int32
+}
+
+func newPhraseIndex(maxLen int) *phraseIndex {
+	x := &phraseIndex{maxLen: int32(maxLen), root: make(map[rune]int32), docs: make(map[string]string)}
+	x.states = append(x.states, phraseState{link: -1})
+	return x
+}
+
+func (x *phraseIndex) next(s int32, r rune) int32 {
+	if s == 0 {
+		if to, ok := x.root[r]; ok {
+			return to
+		}
+		return -1
+	}
+	for _, e := range x.states[s].next {
+		if e.r == r {
+			return e.to
+		}
+	}
+	return -1
+}
+
+func (x *phraseIndex) setNext(s int32, r rune, to int32) {
+	if s == 0 {
+		x.root[r] = to
+		return
+	}
+	st := &x.states[s]
+	for i := range st.next {
+		if st.next[i].r == r {
+			st.next[i].to = to
+			return
+		}
+	}
+	st.next = append(st.next, phraseEdge{r, to})
+}
+
+// edges returns the outgoing edges of s
+func (x *phraseIndex) edges(s int32) []phraseEdge {
+	if s != 0 {
+		return x.states[s].next
+	}
+	edges := make([]phraseEdge, 0, len(x.root))
+	for r, to := range x.root {
+		edges = append(edges, phraseEdge{r, to})
+	}
+	return edges
+}
+
+// clone splits q: the clone takes its substrings up to length n
+func (x *phraseIndex) clone(q int32, n int32) int32 {
+	st := x.states[q]
+	st.len = n
+	st.next = append([]phraseEdge(nil), st.next...)
+	x.states = append(x.states, st)
+	c := int32(len(x.states) - 1)
+	x.states[q].link = c
+	return c
+}
+
+// extend appends r to the segment ending in state last and returns the state of
+// the extended segment
+func (x *phraseIndex) extend(last int32, r rune, seg, end int32) int32 {
+	if q := x.next(last, r); q != -1 {
+		// 其他标注里已有这段文本
+		if x.states[q].len == x.states[last].len+1 {
+			return q
+		}
+		c := x.clone(q, x.states[last].len+1)
+		for p := last; p != -1 && x.next(p, r) == q; p = x.states[p].link {
+			x.setNext(p, r, c)
+		}
+		return c
+	}
+
+	x.states = append(x.states, phraseState{len: x.states[last].len + 1, seg: seg, end: end})
+	cur := int32(len(x.states) - 1)
+	p := last
+	for p != -1 && x.next(p, r) == -1 {
+		x.setNext(p, r, cur)
+		p = x.states[p].link
+	}
+	if p == -1 {
+		return cur
+	}
+	q := x.next(p, r)
+	if x.states[p].len+1 == x.states[q].len {
+		x.states[cur].link = q
+		return cur
+	}
+	c := x.clone(q, x.states[p].len+1)
+	for ; p != -1 && x.next(p, r) == q; p = x.states[p].link {
+		x.setNext(p, r, c)
+	}
+	x.states[cur].link = c
+	return cur
+}
+
+// count adds delta to the caption count of every state with a substring of the
+// segments no longer than maxLen, once per state
+func (x *phraseIndex) count(segments [][]rune, delta int32) {
+	x.visit(segments, func(s int32) { x.states[s].docs += delta })
+}
+
+// setCounts returns for every state the number of texts containing its
+// substrings; the texts must have been added
+func (x *phraseIndex) setCounts(texts []string) []int32 {
+	counts := make([]int32, len(x.states))
+	for _, text := range texts {
+		x.visit(phraseSegments(text), func(s int32) { counts[s]++ })
+	}
+	return counts
+}
+
+// visit calls fn once for every state with a substring of the segments no
+// longer than maxLen
+func (x *phraseIndex) visit(segments [][]rune, fn func(s int32)) {
+	x.stamp++
+	for _, seg := range segments {
+		cur, n := int32(0), int32(0)
+		for _, r := range seg {
+			cur = x.next(cur, r)
+			if n++; n > x.maxLen {
+				// 只统计不超过最大长度的子串，跳到长度为上限的后缀所在状态
+				n = x.maxLen
+				for x.states[x.states[cur].link].len >= x.maxLen {
+					cur = x.states[cur].link
+				}
+			}
+			for s := cur; s > 0 && x.states[s].seen != x.stamp; s = x.states[s].link {
+				x.states[s].seen = x.stamp
+				fn(s)
+			}
+		}
+	}
+}
+
+// phraseSegments splits a caption into the punctuation-free runs phrases come from
+func phraseSegments(text string) [][]rune {
+	parts := splitByPunctuation(text)
+	segments := make([][]rune, len(parts))
+	for i, part := range parts {
+		segments[i] = []rune(part)
+	}
+	return segments
+}
+
+func (x *phraseIndex) add(id string, text string) {
+	segments := phraseSegments(text)
+	for _, seg := range segments {
+		x.segments = append(x.segments, seg)
+		si := int32(len(x.segments) - 1)
+		last := int32(0)
+		for i, r := range seg {
+			last = x.extend(last, r, si, int32(i+1))
+		}
+	}
+	x.count(segments, 1)
+	x.docs[id] = text
+	x.live += utf8.RuneCountInString(text)
+}
+
+func (x *phraseIndex) remove(id string) {
+	text, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	x.count(phraseSegments(text), -1)
+	delete(x.docs, id)
+	n := utf8.RuneCountInString(text)
+	x.live -= n
+	x.dead += n
+}
+
+// sync brings the index in line with the captions of items; only captions that
+// changed since the last sync are counted again
+func (x *phraseIndex) sync(items []DatasetItem) *phraseIndex {
+	current := make(map[string]bool, len(items))
+	for _, item := range items {
+		current[item.ID] = true
+	}
+	for id := range x.docs {
+		if !current[id] {
+			x.remove(id)
+		}
+	}
+	for _, item := range items {
+		if text, ok := x.docs[item.ID]; ok && text == item.RawTags {
+			continue
+		}
+		x.remove(item.ID)
+		if x.dead > x.live && x.dead > 1<<16 {
+			// 被替换的标注留下的状态过多，重建索引
+			return newPhraseIndex(int(x.maxLen)).sync(items)
+		}
+		x.add(item.ID, item.RawTags)
+	}
+	return x
+}
+
+// phraseLen is the length of the longest substring of s, cut to maxLen
+func (x *phraseIndex) phraseLen(s int32) int32 {
+	return min(x.states[s].len, x.maxLen)
+}
+
+// maximal reports for every state whether its phrase, the longest substring
+// cut to maxLen runes, is kept. A phrase is dropped when a phrase containing it
+// occurs in the same captions, judged by same; it is enough to look at phrases
+// one rune longer, which are the suffix-link children (one rune to the left)
+// and the edges (one to the right). States whose substrings are all longer than
+// maxLen have no phrase.
+func (x *phraseIndex) maximal(same func(s, t int32) bool) []bool {
+	keep := make([]bool, len(x.states))
+	for s := int32(1); s < int32(len(x.states)); s++ {
+		keep[s] = x.phraseLen(s) > x.states[x.states[s].link].len
+	}
+	for t := int32(1); t < int32(len(x.states)); t++ {
+		if s := x.states[t].link; s > 0 && x.states[s].len < x.maxLen && same(s, t) {
+			keep[s] = false
+		}
+	}
+	for s := int32(1); s < int32(len(x.states)); s++ {
+		if !keep[s] || x.phraseLen(s) == x.maxLen {
+			continue
+		}
+		for _, e := range x.edges(s) {
+			if same(s, e.to) {
+				keep[s] = false
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// text spells out the longest substring of s, cut to n runes from its end
+func (x *phraseIndex) text(s int32, n int32) string {
+	st := x.states[s]
+	return string(x.segments[st.seg][st.end-n : st.end])
+}
+
+// common returns the phrases of cfg.MinChars to maxLen runes in at least
+// cfg.MinDocs captions that filter allows, most frequent first, then longest
+// first. A phrase is dropped when a longer phrase containing it occurs in the
+// same captions, see maximal. The filter applies after that, so a blocked
+// phrase hides its parts too.
+func (x *phraseIndex) common(cfg PhraseConfig, maxDocs int32, filter phraseFilter) []TagInfo {
+	keep := x.maximal(func(s, t int32) bool { return x.states[s].docs == x.states[t].docs })
+
+	type candidate struct {
+		state int32
+		n     int32
+		docs  int32
+	}
+	candidates := make([]candidate, 0)
+	for s := int32(1); s < int32(len(x.states)); s++ {
+		st := x.states[s]
+		n := x.phraseLen(s)
+		if !keep[s] || st.docs < int32(cfg.MinDocs) || st.docs > maxDocs || n < int32(cfg.MinChars) {
+			continue
+		}
+		candidates = append(candidates, candidate{s, n, st.docs})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if ci.docs != cj.docs {
+			return ci.docs > cj.docs
+		}
+		if ci.n != cj.n {
+			return ci.n > cj.n
+		}
+		// 次数和长度都相同时按文本排序，保证结果稳定
+		return x.text(ci.state, ci.n) < x.text(cj.state, cj.n)
+	})
+	phrases := make([]TagInfo, 0, cfg.Limit)
+	for _, c := range candidates {
+		if len(phrases) == cfg.Limit {
+			break
+		}
+		if text := x.text(c.state, c.n); filter.allows(text) {
+			phrases = append(phrases, TagInfo{Tag: text, Count: int(c.docs)})
+		}
+	}
+	return phrases
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// baselineCommonPhrases is the substring counting the phrase index replaced:
+// every punctuation-free substring of minLen to maxLen runes, counted once per
+// caption, keeping those in at least minDocs captions and dropping the ones a
+// longer kept phrase with the same count contains
+func baselineCommonPhrases(captions []string, minLen, maxLen, minDocs int) []TagInfo {
+	docs := make(map[string]int)
+	for _, caption := range captions {
+		seen := make(map[string]bool)
+		for _, segment := range splitByPunctuation(caption) {
+			runes := []rune(segment)
+			for i := range runes {
+				for l := minLen; l <= maxLen && i+l <= len(runes); l++ {
+					seen[string(runes[i:i+l])] = true
+				}
+			}
+		}
+		for sub := range seen {
+			docs[sub]++
+		}
+	}
+
+	common := make([]TagInfo, 0)
+	for phrase, n := range docs {
+		if n >= minDocs {
+			common = append(common, TagInfo{Tag: phrase, Count: n})
+		}
+	}
+	result := make([]TagInfo, 0)
+	for _, p := range common {
+		covered := false
+		for _, q := range common {
+			if len(q.Tag) > len(p.Tag) && q.Count == p.Count && strings.Contains(q.Tag, p.Tag) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, p)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		if li, lj := len([]rune(result[i].Tag)), len([]rune(result[j].Tag)); li != lj {
+			return li > lj
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+func phraseCounts(phrases []TagInfo) []TagInfo {
+	out := make([]TagInfo, len(phrases))
+	for i, p := range phrases {
+		out[i] = TagInfo{Tag: p.Tag, Count: p.Count}
+	}
+	return out
+}
+
+func TestPhraseIndexMatchesBaseline(t *testing.T) {
+	captions := map[string]string{
+		"a": "一个女孩站在海边，正面视角",
+		"b": "一个女孩坐在海边，侧面视角",
+		"c": "两个女孩站在街上，正面视角",
+		"d": "blue sky, white clouds",
+		"e": "blue sky",
+		"f": "",
+		"g": "海边海边海边。一个女孩",
+	}
+	// 编辑、删除和新增标注后增量同步的结果也要一致
+	edited := map[string]string{
+		"a": "一个男孩站在海边，正面视角",
+		"b": captions["b"],
+		"d": captions["d"],
+		"e": "white clouds, blue sky",
+		"f": captions["f"],
+		"g": captions["g"],
+		"h": "侧面视角的女孩站在海边",
+	}
+
+	for _, tt := range []struct {
+		name                        string
+		minChars, maxChars, minDocs int
+	}{
+		{"defaults", 2, 15, 2},
+		{"short max", 2, 3, 2},
+		{"single runes", 1, 4, 3},
+	} {
+		cfg := defaultPhraseConfig()
+		cfg.Mode = PhraseModeChars
+		cfg.MinChars, cfg.MaxChars, cfg.MinDocs = tt.minChars, tt.maxChars, tt.minDocs
+		cfg.Limit = 1000
+
+		app := NewApp()
+		app.phraseConfig = cfg
+		for step, set := range []map[string]string{captions, edited} {
+			app.items = app.items[:0]
+			texts := make([]string, 0, len(set))
+			ids := make([]string, 0, len(set))
+			for id := range set {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				app.items = append(app.items, DatasetItem{ID: id, RawTags: set[id]})
+				texts = append(texts, set[id])
+			}
+
+			got := phraseCounts(app.analyzeCommonPhrases())
+			want := baselineCommonPhrases(texts, tt.minChars, tt.maxChars, tt.minDocs)
+			if len(want) == 0 {
+				t.Fatalf("%s, step %d: baseline found no phrases", tt.name, step)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, step %d:\n got %v\nwant %v", tt.name, step, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reorder strategies accepted by BatchReorderTags
+const (
+	// ReorderFrequency puts the tags most frequent in the dataset first
+	ReorderFrequency = "frequency"
+	// ReorderCategory orders by dictionary category: character, copyright,
+	// artist, general, meta, then tags missing from the dictionary
+	ReorderCategory = "category"
+	// ReorderAlphabetical sorts case-insensitively by the bare tag
+	ReorderAlphabetical = "alphabetical"
+	// ReorderPriority puts the tags of a priority list first, in its order
+	ReorderPriority = "priority"
+)
+
+var reorderStrategies = []string{ReorderFrequency, ReorderCategory, ReorderAlphabetical, ReorderPriority}
+
+// ReorderOptions describes how BatchReorderTags sorts the tags of each caption.
+// Ties, and tags missing from Priority, keep their original relative order.
+type ReorderOptions struct {
+	Strategy string   `json:"strategy"`
+	Priority []string `json:"priority,omitempty"`
+}
+
+// reorderCaptionTags sorts the tags of every tag line by key, leaving the first
+// protected tags of the caption in place. Only the tag texts move: separators,
+// line endings and emphasis stay exactly as written.
+func reorderCaptionTags(raw string, cfg CaptionConfig, protected int, key func(tag string) tagSortKey) string {
+	edits := make([]spanEdit, 0)
+	seen := 0
+	for _, line := range splitCaptionLines(raw, cfg) {
+		if !line.tags {
+			continue
+		}
+		spans := tagListSpans(line.text)
+		movable := make([]tagSpan, 0, len(spans))
+		for _, span := range spans {
+			if seen >= protected {
+				movable = append(movable, span)
+			}
+			seen++
+		}
+
+		texts := make([]string, len(movable))
+		keys := make([]tagSortKey, len(movable))
+		for i, span := range movable {
+			texts[i] = line.text[span.start:span.end]
+			keys[i] = key(bareTag(texts[i]))
+		}
+		order := make([]int, len(movable))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return keys[order[i]].less(keys[order[j]])
+		})
+		for i, span := range movable {
+			if text := texts[order[i]]; text != texts[i] {
+				edits = append(edits, spanEdit{start: line.start + span.start, end: line.start + span.end, text: text})
+			}
+		}
+	}
+	return applySpanEdits(raw, edits)
+}
+
+// tagSortKey orders by rank, then by text; the zero text compares equal so the
+// original order decides
+type tagSortKey struct {
+	rank int
+	text string
+}
+
+func (k tagSortKey) less(o tagSortKey) bool {
+	if k.rank != o.rank {
+		return k.rank < o.rank
+	}
+	return k.text < o.text
+}
+
+// reorderKey returns the sort key of a bare tag under opts; the caller must hold a.mu
+func (a *App) reorderKey(opts ReorderOptions) (func(tag string) tagSortKey, error) {
+	switch opts.Strategy {
+	case ReorderFrequency:
+		return func(tag string) tagSortKey {
+			return tagSortKey{rank: -a.tagFrequency[tag]}
+		}, nil
+	case ReorderCategory:
+		if a.dictionary == nil {
+			return nil, fmt.Errorf("sorting by category needs a tag dictionary")
+		}
+		rules := a.tagRules()
+		return func(tag string) tagSortKey {
+			rank := indexOfString(tagCategories, a.tagCategory(tag, rules))
+			if rank == -1 {
+				rank = len(tagCategories)
+			}
+			return tagSortKey{rank: rank}
+		}, nil
+	case ReorderAlphabetical:
+		return func(tag string) tagSortKey {
+			return tagSortKey{text: strings.ToLower(tag)}
+		}, nil
+	case ReorderPriority:
+		priority := normalizeKeepTokens(opts.Priority)
+		if len(priority) == 0 {
+			return nil, fmt.Errorf("sorting by priority needs a priority list")
+		}
+		return func(tag string) tagSortKey {
+			rank := indexOfString(priority, tag)
+			if rank == -1 {
+				rank = len(priority)
+			}
+			return tagSortKey{rank: rank}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown reorder strategy: %s", opts.Strategy)
+}
+
+// reorderedCaption returns the caption of the item at idx sorted under key, with
+// its keep tokens pinned; the caller must hold a.mu
+func (a *App) reorderedCaption(idx int, key func(tag string) tagSortKey) string {
+	item := a.items[idx]
+	protected := protectedTagCount(item.Tags, a.keepTokens(item))
+	return reorderCaptionTags(item.RawTags, a.config.Caption, protected, key)
+}
+
+// PreviewReorderTags returns the captions of itemIDs that reordering would
+// change, without modifying anything
+func (a *App) PreviewReorderTags(itemIDs []string, opts ReorderOptions) ([]CaptionChange, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, err := a.reorderKey(opts)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]CaptionChange, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		if after := a.reorderedCaption(idx, key); after != item.RawTags {
+			changes = append(changes, CaptionChange{ID: item.ID, Path: item.MediaPath, Before: item.RawTags, After: after})
+		}
+	}
+	return changes, nil
+}
+
+// BatchReorderTags sorts the tags of each caption of itemIDs without adding,
+// removing or rewriting any; the keep tokens at the start stay pinned. Like the
+// other batch operations the captions are written by SaveAllChanges.
+func (a *App) BatchReorderTags(itemIDs []string, opts ReorderOptions) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, err := a.reorderKey(opts)
+	if err != nil {
+		return err
+	}
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		newTags := a.reorderedCaption(idx, key)
+		if newTags == a.items[idx].RawTags {
+			continue
+		}
+		a.setItemCaption(idx, newTags)
+		a.items[idx].Modified = true
+	}
+	return nil
+}
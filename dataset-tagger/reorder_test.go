@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReorderCaptionTags(t *testing.T) {
+	alphabetical := func(tag string) tagSortKey { return tagSortKey{text: strings.ToLower(tag)} }
+	same := func(tag string) tagSortKey { return tagSortKey{} }
+	cases := []struct {
+		name      string
+		raw       string
+		cfg       CaptionConfig
+		protected int
+		key       func(string) tagSortKey
+		want      string
+	}{
+		{"emphasis moves with its tag", "b, (a:1.2), c", tagsMode, 0, alphabetical, "(a:1.2), b, c"},
+		{"separators stay in place", "c,b ,  a", tagsMode, 0, alphabetical, "a,b ,  c"},
+		{"protected tags pinned", "z, b, a", tagsMode, 1, alphabetical, "z, a, b"},
+		{"ties keep their order", "b, a, c", tagsMode, 0, same, "b, a, c"},
+		{"sentences untouched", "b, a\nA sentence, with commas.", mixedMode, 0, alphabetical, "a, b\nA sentence, with commas."},
+		{"case-insensitive", "Beta, alpha", tagsMode, 0, alphabetical, "alpha, Beta"},
+	}
+	for _, c := range cases {
+		if got := reorderCaptionTags(c.raw, c.cfg, c.protected, c.key); got != c.want {
+			t.Errorf("%s: reorderCaptionTags(%q) = %q, want %q", c.name, c.raw, got, c.want)
+		}
+	}
+}
+
+func TestReorderTagsStrategies(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		datasetConfigFile: `{"dictionary": {"path": "tags.csv"}, "keepTokens": {"tokens": ["mychar"]}}`,
+		"tags.csv":        "hatsune_miku,4,100\nsmile,0,100\nsolo,0,100\nhighres,5,100\n",
+		"a.png":           "",
+		"a.txt":           "mychar, smile, solo, hatsune miku, highres",
+		"b.png":           "",
+		"b.txt":           "solo, smile",
+		"c.png":           "",
+		"c.txt":           "solo, wings",
+	})
+	app := scanTestDataset(t, root)
+	a, b, c := testItem(t, app, "a.png").ID, testItem(t, app, "b.png").ID, testItem(t, app, "c.png").ID
+	ids := []string{a, b, c}
+
+	cases := []struct {
+		opts ReorderOptions
+		want string
+	}{
+		{ReorderOptions{Strategy: ReorderFrequency}, "mychar, solo, smile, hatsune miku, highres"},
+		{ReorderOptions{Strategy: ReorderCategory}, "mychar, hatsune miku, smile, solo, highres"},
+		{ReorderOptions{Strategy: ReorderAlphabetical}, "mychar, hatsune miku, highres, smile, solo"},
+		{ReorderOptions{Strategy: ReorderPriority, Priority: []string{"highres", " solo "}}, "mychar, highres, solo, smile, hatsune miku"},
+	}
+	for _, tc := range cases {
+		changes, err := app.PreviewReorderTags(ids, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.opts.Strategy, err)
+		}
+		var after string
+		for _, change := range changes {
+			if change.ID == a {
+				after = change.After
+			}
+			if change.ID == c {
+				t.Errorf("%s: preview changes c to %q", tc.opts.Strategy, change.After)
+			}
+		}
+		if after != tc.want {
+			t.Errorf("%s: a = %q, want %q", tc.opts.Strategy, after, tc.want)
+		}
+	}
+	if item := testItem(t, app, "a.png"); item.Modified || item.RawTags != "mychar, smile, solo, hatsune miku, highres" {
+		t.Fatal("preview modified the caption")
+	}
+
+	if err := app.BatchReorderTags(ids, ReorderOptions{Strategy: ReorderAlphabetical}); err != nil {
+		t.Fatal(err)
+	}
+	if item := testItem(t, app, "a.png"); !item.Modified || item.RawTags != "mychar, hatsune miku, highres, smile, solo" {
+		t.Fatalf("a after reorder = %q modified %v", item.RawTags, item.Modified)
+	}
+	if item := testItem(t, app, "b.png"); !item.Modified || item.RawTags != "smile, solo" {
+		t.Fatalf("b after reorder = %q modified %v", item.RawTags, item.Modified)
+	}
+	if item := testItem(t, app, "c.png"); item.Modified {
+		t.Fatal("caption already in order marked modified")
+	}
+
+	for _, opts := range []ReorderOptions{{Strategy: "random"}, {Strategy: ReorderPriority, Priority: []string{" "}}} {
+		if _, err := app.PreviewReorderTags(ids, opts); err == nil {
+			t.Errorf("%+v accepted", opts)
+		}
+		if err := app.BatchReorderTags(ids, opts); err == nil {
+			t.Errorf("batch %+v accepted", opts)
+		}
+	}
+}
+
+func TestReorderByCategoryNeedsDictionary(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "solo, smile",
+	})
+	app := scanTestDataset(t, root)
+	if _, err := app.PreviewReorderTags([]string{testItem(t, app, "a.png").ID}, ReorderOptions{Strategy: ReorderCategory}); err == nil {
+		t.Fatal("category order accepted without a dictionary")
+	}
+}
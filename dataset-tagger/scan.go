@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventScanProgress carries a ScanProgress while ScanFolder runs
+const EventScanProgress = "scan:progress"
+
+// Scan phases reported in ScanProgress.Phase
+const (
+	ScanPhaseWalk    = "walk"    // 遍历目录
+	ScanPhaseRead    = "read"    // 读取标注文件
+	ScanPhaseAnalyze = "analyze" // 短语统计
+	ScanPhaseDone    = "done"
+)
+
+// progressInterval throttles progress events so the UI is not flooded
+const progressInterval = 150 * time.Millisecond
+
+// ScanProgress is emitted periodically while a folder is scanned
+type ScanProgress struct {
+	Phase        string `json:"phase"`
+	Discovered   int    `json:"discovered"`
+	CaptionsRead int    `json:"captionsRead"`
+	Total        int    `json:"total"`
+}
+
+// scanJob is one media key waiting for its caption to be read
+type scanJob struct {
+	key      string
+	media    string
+	txtPath  string
+	sidecars []string
+	isVideo  bool
+}
+
+// scanOutput is what a worker produces for a scanJob
+type scanOutput struct {
+	item  DatasetItem
+	issue *ScanIssue
+}
+
+// scanWorkers returns the size of the caption reader pool. Reading is I/O bound,
+// so more workers than CPUs helps on network shares.
+func scanWorkers() int {
+	n := runtime.NumCPU() * 2
+	if n < 4 {
+		n = 4
+	}
+	if n > 32 {
+		n = 32
+	}
+	return n
+}
+
+// scanFolder walks folderPath, reads captions concurrently and replaces the loaded
+// dataset once everything succeeded. A cancelled scan leaves the old state untouched.
+func (a *App) scanFolder(ctx context.Context, folderPath string, progress func(ScanProgress)) ScanResult {
+	if progress == nil {
+		progress = func(ScanProgress) {}
+	}
+	var state ScanProgress
+	last := time.Time{}
+	report := func(force bool) {
+		if force || time.Since(last) >= progressInterval {
+			last = time.Now()
+			progress(state)
+		}
+	}
+
+	// 压缩包作为只读的数据集根目录，条目路径位于压缩包路径之下
+	var src datasetSource = osSource{}
+	if isArchiveFile(folderPath) {
+		archive, err := openArchive(folderPath)
+		if err != nil {
+			return ScanResult{Success: false, Message: fmt.Sprintf("打开压缩包失败: %v", err)}
+		}
+		src = archive
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			src.Close()
+		}
+	}()
+
+	issues := make([]ScanIssue, 0)
+	cfg, err := loadDatasetConfig(src, folderPath)
+	if err != nil {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: filepath.Join(folderPath, datasetConfigFile), Message: err.Error()})
+	}
+	pairing := newPairer(folderPath, cfg.Pairing).ignoring(cfg.files(folderPath))
+	datasetRules, err := loadTagRules(src, filepath.Join(folderPath, tagRulesFile))
+	if err != nil {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: filepath.Join(folderPath, tagRulesFile), Message: err.Error()})
+	}
+	dictionary, err := loadTagDictionary(src, folderPath, cfg.Dictionary)
+	if err != nil {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: dictionaryPath(folderPath, cfg.Dictionary), Message: err.Error()})
+	}
+	segmenter, err := loadSegmenter(src, folderPath, cfg.Segment)
+	if err != nil {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: configFilePath(folderPath, cfg.Segment.UserDict), Message: err.Error()})
+	}
+	if segmenter == nil {
+		// 用户词典读不了时只用内置词典
+		segmenter, _ = newSegmenter(nil)
+	}
+	phraseFilter, err := loadPhraseFilter(src, folderPath, cfg.Phrases)
+	if err != nil {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: filepath.Join(folderPath, datasetConfigFile), Message: err.Error()})
+	}
+	globalRules, err := loadGlobalTagRules()
+	if err != nil {
+		path, _ := globalTagRulesPath()
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: path, Message: err.Error()})
+	}
+
+	mediaFiles := make(map[string][]string)
+	captionFiles := make(map[string][]string)
+
+	// Walk through directory
+	state.Phase = ScanPhaseWalk
+	report(true)
+	err = walkDataset(src, folderPath, cfg.Walk, func(path string, info os.FileInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch role, key := pairing.classify(path); role {
+		case fileImage, fileVideo:
+			mediaFiles[key] = append(mediaFiles[key], path)
+		case fileCaption, fileSidecar:
+			captionFiles[key] = append(captionFiles[key], path)
+		default:
+			return nil
+		}
+
+		state.Discovered++
+		report(false)
+		return nil
+	}, func(path string, err error) {
+		issues = append(issues, ScanIssue{Kind: IssueUnreadable, Path: path, Message: err.Error()})
+	})
+
+	if errors.Is(err, context.Canceled) {
+		return ScanResult{Success: false, Message: "扫描已取消"}
+	}
+	if err != nil {
+		return ScanResult{Success: false, Message: fmt.Sprintf("扫描失败: %v", err)}
+	}
+
+	// 没有对应媒体文件的标注文件
+	for key, paths := range captionFiles {
+		if _, ok := mediaFiles[key]; !ok {
+			for _, path := range paths {
+				issues = append(issues, ScanIssue{Kind: IssueOrphanCaption, Path: path})
+			}
+		}
+	}
+
+	// Match media files with caption files
+	jobs := make([]scanJob, 0, len(mediaFiles))
+	for key, paths := range mediaFiles {
+		// 同名不同扩展名的媒体共用一个标注文件，只保留第一个，其余作为冲突报告
+		sort.Strings(paths)
+		if len(paths) > 1 {
+			issues = append(issues, ScanIssue{Kind: IssueCollision, Path: paths[0], Related: paths[1:]})
+		}
+		txtPath, sidecars := pairing.pickCaption(captionFiles[key])
+		jobs = append(jobs, scanJob{key: key, media: paths[0], txtPath: txtPath, sidecars: sidecars, isVideo: pairing.isVideo(paths[0])})
+	}
+
+	state.Phase = ScanPhaseRead
+	state.Total = len(jobs)
+	report(true)
+	outputs, err := a.readCaptions(ctx, src, folderPath, cfg.Caption, jobs, func(done int) {
+		state.CaptionsRead = done
+		report(false)
+	})
+	if err != nil {
+		return ScanResult{Success: false, Message: "扫描已取消"}
+	}
+	state.CaptionsRead = len(jobs)
+
+	items := make([]DatasetItem, 0, len(outputs))
+	tagFrequency := make(map[string]int)
+	totalImages := 0
+	totalVideos := 0
+	for _, out := range outputs {
+		if out.issue != nil {
+			issues = append(issues, *out.issue)
+		}
+		if out.item.IsVideo {
+			totalVideos++
+		} else {
+			totalImages++
+		}
+		for _, tag := range out.item.Tags {
+			tagFrequency[tag]++
+		}
+		items = append(items, out.item)
+	}
+	sortIssues(issues)
+
+	state.Phase = ScanPhaseAnalyze
+	report(true)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.watcher != nil && a.watcher.root != folderPath {
+		a.stopWatcher()
+	}
+	if a.source != nil {
+		a.source.Close()
+	}
+	a.source = src
+	committed = true
+	a.archiveChanges = nil
+	a.datasetPath = folderPath
+	a.config = cfg
+	a.datasetRules = datasetRules
+	a.globalRules = globalRules
+	a.dictionary = dictionary
+	a.segmenter = segmenter
+	a.phraseConfig = cfg.Phrases
+	a.phraseFilter = phraseFilter
+	a.pairing = pairing
+	a.items = items
+	a.tagFrequency = tagFrequency
+	a.phrases = newPhraseIndex(cfg.Phrases.MaxChars)
+	a.words = newWordIndex(segmenter, cfg.Phrases.MaxWords)
+	a.issues = issues
+
+	// mediaFiles 是 map，遍历顺序随机，按当前排序规则重新排列
+	a.applySortOrder()
+
+	// 分析共同短语（子串频率统计）
+	tagInfos := a.analyzeCommonPhrases()
+
+	state.Phase = ScanPhaseDone
+	report(true)
+
+	return ScanResult{
+		Success:     true,
+		Message:     fmt.Sprintf("成功扫描 %d 个文件", len(a.items)),
+		Items:       append([]DatasetItem(nil), a.items...),
+		Tags:        tagInfos,
+		Categories:  a.categoryStats(),
+		TotalItems:  len(a.items),
+		TotalImages: totalImages,
+		TotalVideos: totalVideos,
+		Issues:      issues,
+		Concepts:    conceptStats(a.items),
+		Archive:     a.loadedArchive() != nil,
+	}
+}
+
+// readCaptions reads the caption of every job on a worker pool. Outputs keep the
+// order of jobs. onRead is called from the calling goroutine only.
+func (a *App) readCaptions(ctx context.Context, src datasetSource, root string, caption CaptionConfig, jobs []scanJob, onRead func(done int)) ([]scanOutput, error) {
+	outputs := make([]scanOutput, len(jobs))
+	indexes := make(chan int)
+	finished := make(chan struct{}, len(jobs))
+
+	var wg sync.WaitGroup
+	for w := 0; w < scanWorkers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				outputs[i] = a.readScanJob(src, root, caption, jobs[i])
+				finished <- struct{}{}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexes)
+		for i := range jobs {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	done := 0
+	for done < len(jobs) {
+		select {
+		case <-finished:
+			done++
+			onRead(done)
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+	}
+	wg.Wait()
+	return outputs, nil
+}
+
+// readScanJob builds the item for one media file and reads its caption
+func (a *App) readScanJob(src datasetSource, root string, caption CaptionConfig, job scanJob) scanOutput {
+	item := DatasetItem{
+		ID:        job.key,
+		MediaPath: job.media,
+		TxtPath:   job.txtPath,
+		IsVideo:   job.isVideo,
+		Sidecars:  job.sidecars,
+		Tags:      []string{},
+		RawTags:   "",
+	}
+	applyKohyaInfo(root, &item)
+	applyMediaInfo(src, &item)
+
+	if job.txtPath == "" {
+		return scanOutput{item: item, issue: &ScanIssue{Kind: IssueMissingCaption, Path: job.media}}
+	}
+
+	// Read tags from caption file
+	content, err := src.ReadFile(job.txtPath)
+	if err != nil {
+		return scanOutput{item: item, issue: &ScanIssue{Kind: IssueUnreadable, Path: job.txtPath, Message: err.Error()}}
+	}
+	item.RawTags = string(content)
+	// 扫描期间 a.config 仍是旧数据集的设置
+	item.Tags = captionTags(item.RawTags, caption)
+	if captionEmpty(item.RawTags, caption) {
+		return scanOutput{item: item, issue: &ScanIssue{Kind: IssueEmptyCaption, Path: job.txtPath}}
+	}
+	return scanOutput{item: item}
+}
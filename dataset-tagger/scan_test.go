@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSupersededScanKeepsNewerCancel(t *testing.T) {
+	app := NewApp()
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+
+	firstGen := app.beginScan(cancelFirst)
+	secondGen := app.beginScan(cancelSecond)
+	if first.Err() == nil {
+		t.Fatal("first scan not cancelled by the second")
+	}
+
+	// 被取代的扫描在新扫描开始后才返回
+	app.endScan(firstGen)
+	app.CancelScan()
+	if second.Err() == nil {
+		t.Fatal("CancelScan did not reach the running scan")
+	}
+
+	app.endScan(secondGen)
+	if app.scanCancel != nil {
+		t.Fatal("cancel func kept after the running scan returned")
+	}
+}
+
+// orphanCaptions returns the orphan caption paths of issues, relative to root
+func orphanCaptions(t *testing.T, root string, issues []ScanIssue) []string {
+	t.Helper()
+	paths := make([]string, 0)
+	for _, issue := range issues {
+		if issue.Kind == IssueOrphanCaption {
+			rel, err := filepath.Rel(root, issue.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+	}
+	return paths
+}
+
+func TestScanSkipsPhraseFilterFiles(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":           "",
+		"a.txt":           "blue sky",
+		"stopwords.txt":   "blue",
+		"lists/block.txt": "white clouds",
+		"orphan.txt":      "no image",
+		datasetConfigFile: `{"phrases": {"stopwords": "stopwords.txt", "blocklist": "lists/block.txt"}}`,
+	})
+	app := NewApp()
+	result := app.scanFolder(context.Background(), root, nil)
+	if !result.Success {
+		t.Fatal(result.Message)
+	}
+	if got := orphanCaptions(t, root, result.Issues); len(got) != 1 || got[0] != "orphan.txt" {
+		t.Fatalf("orphan captions = %v, want only orphan.txt", got)
+	}
+	snapshot := takeSnapshot(app.pairing, app.config.Walk, root)
+	for _, name := range []string{"stopwords.txt", "lists/block.txt"} {
+		if _, ok := snapshot[filepath.Join(root, filepath.FromSlash(name))]; ok {
+			t.Errorf("watcher snapshot includes %s", name)
+		}
+	}
+}
+
+func TestSavedPhraseFilterFileIsNoLongerOrphan(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":         "",
+		"a.txt":         "blue sky",
+		"stopwords.txt": "blue",
+	})
+	app := scanTestDataset(t, root)
+	if got := orphanCaptions(t, root, app.GetScanIssues()); len(got) != 1 {
+		t.Fatalf("orphan captions before = %v, want stopwords.txt", got)
+	}
+
+	opts := app.GetDatasetConfig().Phrases
+	opts.Stopwords = "stopwords.txt"
+	if err := app.SavePhraseConfig(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := orphanCaptions(t, root, app.GetScanIssues()); len(got) != 0 {
+		t.Fatalf("orphan captions after saving = %v, want none", got)
+	}
+	if role, _ := app.pairing.classify(filepath.Join(root, "stopwords.txt")); role != fileOther {
+		t.Fatalf("stopwords file classified as %d", role)
+	}
+}
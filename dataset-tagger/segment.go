@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// builtinSegmentDict is the embedded Chinese dictionary, one "word frequency"
+// per line, weighted towards the vocabulary of image captions. It is small
+// (about 1300 words with hand-set frequencies, not jieba's corpus counts) and
+// the HMM learns its emissions from the same list, so words far from caption
+// vocabulary are often split wrongly; a full dictionary such as jieba's dict.txt
+// can be loaded through SegmentConfig.UserDict.
+//
+//go:embed segdict.txt
+var builtinSegmentDict string
+
+// SegmentConfig adds the dataset's own words to the Chinese segmenter, so domain
+// terms such as character names stay in one piece
+type SegmentConfig struct {
+	// UserDict is a dictionary file in jieba's format, one "word [frequency]"
+	// per line; a relative path is resolved against the dataset root
+	UserDict string `json:"userDict"`
+	// Words are extra entries in the same "word [frequency]" form
+	Words []string `json:"words"`
+}
+
+func defaultSegmentConfig() SegmentConfig {
+	cfg := SegmentConfig{}
+	cfg.normalize()
+	return cfg
+}
+
+func (c *SegmentConfig) normalize() {
+	c.UserDict = strings.TrimSpace(c.UserDict)
+	words := make([]string, 0, len(c.Words))
+	for _, w := range c.Words {
+		if w = strings.Join(strings.Fields(w), " "); w != "" {
+			words = append(words, w)
+		}
+	}
+	c.Words = words
+}
+
+func (c *SegmentConfig) validate() error {
+	for _, w := range c.Words {
+		if _, err := parseSegmentEntry(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c SegmentConfig) equal(o SegmentConfig) bool {
+	if c.UserDict != o.UserDict || len(c.Words) != len(o.Words) {
+		return false
+	}
+	for i := range c.Words {
+		if c.Words[i] != o.Words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentEntry is one dictionary word; a zero freq lets the segmenter pick one
+// just high enough to keep the word whole
+type segmentEntry struct {
+	word string
+	freq float64
+}
+
+// parseSegmentEntry reads "word [frequency [part of speech]]"
+func parseSegmentEntry(line string) (segmentEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return segmentEntry{}, fmt.Errorf("empty segment dictionary entry")
+	}
+	entry := segmentEntry{word: strings.ToLower(fields[0])}
+	if len(fields) > 1 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			return segmentEntry{}, fmt.Errorf("bad frequency in segment dictionary entry: %q", line)
+		}
+		entry.freq = float64(n)
+	}
+	return entry, nil
+}
+
+// parseSegmentDict reads a dictionary in jieba's format; blank lines and lines
+// starting with # are skipped
+func parseSegmentDict(r io.Reader) ([]segmentEntry, error) {
+	entries := make([]segmentEntry, 0)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseSegmentEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, sc.Err()
+}
+
+var builtinSegmentEntries = sync.OnceValues(func() ([]segmentEntry, error) {
+	entries, err := parseSegmentDict(strings.NewReader(builtinSegmentDict))
+	if err != nil {
+		return nil, fmt.Errorf("built-in segment dictionary: %v", err)
+	}
+	return entries, nil
+})
+
+// loadSegmenter builds the segmenter of a dataset: the built-in dictionary plus
+// the configured user dictionary and words. A bad user dictionary returns a nil
+// segmenter; a bad built-in dictionary returns its error with a segmenter that
+// works from the user entries alone.
+func loadSegmenter(src datasetSource, root string, cfg SegmentConfig) (*segmenter, error) {
+	user := make([]segmentEntry, 0, len(cfg.Words))
+	if path := configFilePath(root, cfg.UserDict); path != "" {
+		data, err := readConfigFile(src, root, path)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseSegmentDict(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		user = append(user, entries...)
+	}
+	for _, w := range cfg.Words {
+		entry, err := parseSegmentEntry(w)
+		if err != nil {
+			return nil, err
+		}
+		user = append(user, entry)
+	}
+	return newSegmenter(user)
+}
+
+// HMM states of a character: begin, middle or end of a word, or a single
+// character word
+const (
+	hmmB = iota
+	hmmM
+	hmmE
+	hmmS
+)
+
+// hmmMin stands for an impossible transition
+const hmmMin = -3.14e100
+
+// The start and transition log probabilities are jieba's, estimated on a
+// segmented corpus
+var (
+	hmmStart = [4]float64{hmmB: -0.26268660809250016, hmmM: hmmMin, hmmE: hmmMin, hmmS: -1.4652633398537678}
+	hmmTrans = [4][4]float64{
+		hmmB: {hmmB: hmmMin, hmmM: -0.916290731874155, hmmE: -0.510825623765990, hmmS: hmmMin},
+		hmmM: {hmmB: hmmMin, hmmM: -1.2603623820268226, hmmE: -0.33344856811948514, hmmS: hmmMin},
+		hmmE: {hmmB: -0.5897149736854513, hmmM: hmmMin, hmmE: hmmMin, hmmS: -0.8085250474669937},
+		hmmS: {hmmB: -0.7211965654669841, hmmM: hmmMin, hmmE: hmmMin, hmmS: -0.6658631448798212},
+	}
+	// hmmPrev lists the states that may precede each state
+	hmmPrev = [4][]int{hmmB: {hmmE, hmmS}, hmmM: {hmmM, hmmB}, hmmE: {hmmB, hmmM}, hmmS: {hmmS, hmmE}}
+)
+
+// segmenter splits Chinese text into words the way jieba does: the most
+// probable route through the dictionary words of the text, with a hidden Markov
+// model guessing the words the dictionary lacks from runs of single characters.
+// The emission probabilities are learned from the positions of characters in the
+// dictionary words, so nothing beyond the embedded dictionary is needed.
+type segmenter struct {
+	// freq holds the words, and their prefixes with frequency 0
+	freq     map[string]float64
+	total    float64
+	emit     [4]map[rune]float64
+	emitMiss [4]float64
+}
+
+// newSegmenter loads the built-in dictionary and then user. When the built-in
+// dictionary cannot be parsed the segmenter is still returned, built from user
+// alone, together with the error; with no words at all it cuts Chinese text
+// into single characters.
+func newSegmenter(user []segmentEntry) (*segmenter, error) {
+	s := &segmenter{freq: make(map[string]float64)}
+	builtin, err := builtinSegmentEntries()
+	for _, entry := range builtin {
+		s.addWord(entry.word, entry.freq)
+	}
+	for _, entry := range user {
+		freq := entry.freq
+		if freq == 0 {
+			freq = s.suggestFreq(entry.word)
+		}
+		s.addWord(entry.word, freq)
+	}
+	s.train()
+	return s, err
+}
+
+func (s *segmenter) addWord(word string, freq float64) {
+	runes := []rune(word)
+	if old := s.freq[word]; old > 0 {
+		s.total -= old
+	}
+	s.freq[word] = freq
+	s.total += freq
+	for i := 1; i < len(runes); i++ {
+		if _, ok := s.freq[string(runes[:i])]; !ok {
+			s.freq[string(runes[:i])] = 0
+		}
+	}
+}
+
+// suggestFreq returns a frequency that makes the dictionary route keep word whole
+func (s *segmenter) suggestFreq(word string) float64 {
+	if s.total == 0 {
+		return 1
+	}
+	p := 1.0
+	for _, part := range s.route([]rune(word)) {
+		p *= math.Max(s.freq[part], 1) / s.total
+	}
+	return math.Max(math.Floor(p*s.total)+1, s.freq[word])
+}
+
+// train counts where each character occurs in the dictionary words, weighted by
+// the log of the word frequency
+func (s *segmenter) train() {
+	var totals [4]float64
+	for i := range s.emit {
+		s.emit[i] = make(map[rune]float64)
+	}
+	chars := make(map[rune]bool)
+	for word, freq := range s.freq {
+		if freq <= 0 {
+			continue
+		}
+		runes := []rune(word)
+		if !isHanWord(runes) {
+			continue
+		}
+		w := math.Log(freq) + 1
+		for i, r := range runes {
+			state := hmmM
+			switch {
+			case len(runes) == 1:
+				state = hmmS
+			case i == 0:
+				state = hmmB
+			case i == len(runes)-1:
+				state = hmmE
+			}
+			s.emit[state][r] += w
+			totals[state] += w
+			chars[r] = true
+		}
+	}
+	// 加一平滑，词典里没出现过的字在各状态的概率相同
+	v := float64(len(chars) + 1)
+	for state := range s.emit {
+		for r, n := range s.emit[state] {
+			s.emit[state][r] = math.Log((n + 1) / (totals[state] + v))
+		}
+		s.emitMiss[state] = math.Log(1 / (totals[state] + v))
+	}
+}
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+func isHanWord(runes []rune) bool {
+	for _, r := range runes {
+		if !isHan(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// route returns the most probable split of runes into dictionary words; a rune
+// missing from the dictionary stands alone
+func (s *segmenter) route(runes []rune) []string {
+	n := len(runes)
+	logTotal := math.Log(math.Max(s.total, 1))
+	best := make([]float64, n+1)
+	next := make([]int, n+1)
+	for i := n - 1; i >= 0; i-- {
+		best[i], next[i] = math.Inf(-1), i+1
+		for j := i + 1; j <= n; j++ {
+			freq, ok := s.freq[string(runes[i:j])]
+			if !ok && j > i+1 {
+				break
+			}
+			if freq == 0 && j > i+1 {
+				continue
+			}
+			if score := math.Log(math.Max(freq, 1)) - logTotal + best[j]; score > best[i] {
+				best[i], next[i] = score, j
+			}
+		}
+	}
+	words := make([]string, 0)
+	for i := 0; i < n; i = next[i] {
+		words = append(words, string(runes[i:next[i]]))
+	}
+	return words
+}
+
+// cut splits text into words. Runs of single characters that do not form a
+// dictionary word are handed to the HMM; letters and digits mixed in stay whole.
+func (s *segmenter) cut(text string) []string {
+	words := make([]string, 0)
+	buf := make([]rune, 0)
+	flush := func() {
+		switch {
+		case len(buf) == 0:
+		case len(buf) == 1:
+			words = append(words, string(buf))
+		case s.freq[string(buf)] > 0, s.total == 0:
+			// 没有词典时 HMM 无从学习，按单字切分
+			for _, r := range buf {
+				words = append(words, string(r))
+			}
+		default:
+			words = append(words, s.guess(buf)...)
+		}
+		buf = buf[:0]
+	}
+	for _, word := range s.route([]rune(strings.ToLower(text))) {
+		runes := []rune(word)
+		if len(runes) == 1 {
+			buf = append(buf, runes[0])
+			continue
+		}
+		flush()
+		words = append(words, word)
+	}
+	flush()
+	return words
+}
+
+// guess splits runs of Chinese characters with the HMM and keeps other runs whole
+func (s *segmenter) guess(runes []rune) []string {
+	words := make([]string, 0)
+	for start := 0; start < len(runes); {
+		han := isHan(runes[start])
+		end := start + 1
+		for end < len(runes) && isHan(runes[end]) == han {
+			end++
+		}
+		if han {
+			words = append(words, s.viterbi(runes[start:end])...)
+		} else {
+			words = append(words, string(runes[start:end]))
+		}
+		start = end
+	}
+	return words
+}
+
+// viterbi tags each rune B, M, E or S and cuts after every E and S
+func (s *segmenter) viterbi(runes []rune) []string {
+	n := len(runes)
+	prob := make([][4]float64, n)
+	from := make([][4]int, n)
+	for state := range hmmStart {
+		prob[0][state] = hmmStart[state] + s.emission(state, runes[0])
+	}
+	for i := 1; i < n; i++ {
+		for state := range hmmPrev {
+			prob[i][state] = math.Inf(-1)
+			for _, p := range hmmPrev[state] {
+				if v := prob[i-1][p] + hmmTrans[p][state]; v > prob[i][state] {
+					prob[i][state], from[i][state] = v, p
+				}
+			}
+			prob[i][state] += s.emission(state, runes[i])
+		}
+	}
+
+	// 最后一个字只能是词尾或单字词
+	state := hmmE
+	if prob[n-1][hmmS] > prob[n-1][hmmE] {
+		state = hmmS
+	}
+	states := make([]int, n)
+	for i := n - 1; i >= 0; i-- {
+		states[i] = state
+		state = from[i][state]
+	}
+	words := make([]string, 0)
+	start := 0
+	for i, state := range states {
+		if state == hmmE || state == hmmS {
+			words = append(words, string(runes[start:i+1]))
+			start = i + 1
+		}
+	}
+	if start < n {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+func (s *segmenter) emission(state int, r rune) float64 {
+	if p, ok := s.emit[state][r]; ok {
+		return p
+	}
+	return s.emitMiss[state]
+}
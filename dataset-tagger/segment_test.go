@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// breakBuiltinSegmentDict makes the built-in dictionary fail to load for the test
+func breakBuiltinSegmentDict(t *testing.T) {
+	t.Helper()
+	old := builtinSegmentEntries
+	builtinSegmentEntries = func() ([]segmentEntry, error) {
+		return nil, errors.New("built-in segment dictionary: line 1: bad frequency")
+	}
+	t.Cleanup(func() { builtinSegmentEntries = old })
+}
+
+func TestBuiltinSegmentDictParses(t *testing.T) {
+	entries, err := builtinSegmentEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("built-in segment dictionary is empty")
+	}
+}
+
+func TestSegmenterWithoutBuiltinDict(t *testing.T) {
+	breakBuiltinSegmentDict(t)
+
+	seg, err := loadSegmenter(nil, t.TempDir(), SegmentConfig{})
+	if err == nil || seg == nil {
+		t.Fatalf("loadSegmenter = %v, %v; want a fallback segmenter and the error", seg, err)
+	}
+	if got, want := seg.cut("女孩微笑"), []string{"女", "孩", "微", "笑"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cut without any dictionary = %q, want %q", got, want)
+	}
+
+	seg, err = loadSegmenter(nil, t.TempDir(), SegmentConfig{Words: []string{"胡桃"}})
+	if err == nil || seg == nil {
+		t.Fatalf("loadSegmenter = %v, %v; want a fallback segmenter and the error", seg, err)
+	}
+	if got := seg.cut("胡桃微笑"); len(got) == 0 || got[0] != "胡桃" {
+		t.Errorf("cut with user words = %q, want 胡桃 kept whole", got)
+	}
+}
+
+func TestScanReportsBrokenBuiltinSegmentDict(t *testing.T) {
+	breakBuiltinSegmentDict(t)
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "一个女孩在微笑",
+	})
+	app := NewApp()
+	result := app.scanFolder(context.Background(), root, nil)
+	if !result.Success {
+		t.Fatalf("scan failed: %s", result.Message)
+	}
+	found := false
+	for _, issue := range result.Issues {
+		found = found || strings.Contains(issue.Message, "built-in segment dictionary")
+	}
+	if !found {
+		t.Errorf("issues = %+v, want the dictionary error", result.Issues)
+	}
+	if app.segmenter == nil {
+		t.Error("no segmenter after scan")
+	}
+}
+
+func TestScanSkipsSegmentUserDict(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png":           "",
+		"a.txt":           "胡桃在海边",
+		"userdict.txt":    "胡桃 100",
+		"dict/jieba.txt":  "海边 500 n",
+		datasetConfigFile: `{"segment": {"userDict": "userdict.txt"}}`,
+	})
+	app := NewApp()
+	result := app.scanFolder(context.Background(), root, nil)
+	if !result.Success {
+		t.Fatal(result.Message)
+	}
+	if got := orphanCaptions(t, root, result.Issues); len(got) != 1 || got[0] != "dict/jieba.txt" {
+		t.Fatalf("orphan captions = %v, want only the unconfigured dict/jieba.txt", got)
+	}
+
+	// 改用另一个词典后，它也不再是孤立标注，旧词典重新参与配对
+	cfg := app.GetDatasetConfig()
+	cfg.Segment.UserDict = "dict/jieba.txt"
+	if err := app.SaveDatasetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := orphanCaptions(t, root, app.GetScanIssues()); len(got) != 0 {
+		t.Fatalf("orphan captions after saving = %v, want none", got)
+	}
+	if role, _ := app.pairing.classify(filepath.Join(root, "userdict.txt")); role != fileCaption {
+		t.Errorf("old user dictionary classified as %d, want a caption again", role)
+	}
+	if _, ok := takeSnapshot(app.pairing, app.config.Walk, root)[filepath.Join(root, "dict", "jieba.txt")]; ok {
+		t.Error("watcher snapshot includes the user dictionary")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sort keys accepted by SetSortOrder
+const (
+	SortByPath          = "path"
+	SortByModTime       = "mtime"
+	SortBySize          = "size"
+	SortByTagCount      = "tags"
+	SortByCaptionLength = "caption_length"
+	SortByResolution    = "resolution"
+	SortByPhraseMatches = "phrase_matches"
+	SortByCategory      = "category"
+)
+
+var sortKeys = []string{SortByPath, SortByModTime, SortBySize, SortByTagCount, SortByCaptionLength, SortByResolution, SortByPhraseMatches, SortByCategory}
+
+// SortOrder describes how a.items is ordered. Phrase is only used by
+// phrase_matches, Category by category (the item's tag count in that category).
+type SortOrder struct {
+	Key        string `json:"key"`
+	Descending bool   `json:"descending"`
+	Phrase     string `json:"phrase,omitempty"`
+	Category   string `json:"category,omitempty"`
+}
+
+// defaultSortOrder is natural filename order, so rescans always page the same way
+var defaultSortOrder = SortOrder{Key: SortByPath}
+
+// GetSortKeys returns the keys accepted by SetSortOrder
+func (a *App) GetSortKeys() []string {
+	return sortKeys
+}
+
+// GetSortOrder returns the order currently applied to the items
+func (a *App) GetSortOrder() SortOrder {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.sortOrder
+}
+
+// SetSortOrder reorders all items; pagination, filtering and exports follow the new order
+func (a *App) SetSortOrder(order SortOrder) ([]DatasetItem, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if order.Key == "" {
+		order.Key = SortByPath
+	}
+	valid := false
+	for _, k := range sortKeys {
+		if k == order.Key {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return a.items, fmt.Errorf("unknown sort key: %s", order.Key)
+	}
+	if order.Key == SortByPhraseMatches && order.Phrase == "" {
+		return a.items, fmt.Errorf("sort key %s requires a phrase", order.Key)
+	}
+	if order.Key == SortByCategory && !containsString(tagCategories, order.Category) {
+		return a.items, fmt.Errorf("unknown tag category: %s", order.Category)
+	}
+
+	a.sortOrder = order
+	a.applySortOrder()
+	return append([]DatasetItem(nil), a.items...), nil
+}
+
+// applySortOrder sorts a.items by the current order, with natural path order as tie-breaker
+func (a *App) applySortOrder() {
+	order := a.sortOrder
+	if order.Key == "" {
+		order = defaultSortOrder
+	}
+
+	// 预先计算排序值，避免在比较函数中重复计算
+	values := make(map[string]int64, len(a.items))
+	if order.Key == SortByCategory {
+		rules := a.tagRules()
+		for _, item := range a.items {
+			values[item.ID] = int64(a.itemCategoryCounts(item, rules)[order.Category])
+		}
+	} else if order.Key != SortByPath {
+		for _, item := range a.items {
+			values[item.ID] = sortValue(item, order)
+		}
+	}
+
+	sort.SliceStable(a.items, func(i, j int) bool {
+		if order.Key != SortByPath {
+			vi, vj := values[a.items[i].ID], values[a.items[j].ID]
+			if vi != vj {
+				if order.Descending {
+					return vi > vj
+				}
+				return vi < vj
+			}
+		}
+		if order.Key == SortByPath && order.Descending {
+			return naturalLess(a.items[j].MediaPath, a.items[i].MediaPath)
+		}
+		return naturalLess(a.items[i].MediaPath, a.items[j].MediaPath)
+	})
+}
+
+// sortValue returns the numeric value an item is ordered by
+func sortValue(item DatasetItem, order SortOrder) int64 {
+	switch order.Key {
+	case SortByModTime:
+		return item.ModTime
+	case SortBySize:
+		return item.Bytes
+	case SortByTagCount:
+		return int64(len(item.Tags))
+	case SortByCaptionLength:
+		return int64(utf8.RuneCountInString(item.RawTags))
+	case SortByResolution:
+		return int64(item.Width) * int64(item.Height)
+	case SortByPhraseMatches:
+		return int64(strings.Count(item.RawTags, order.Phrase))
+	}
+	return 0
+}
+
+// naturalLess compares strings case-insensitively, treating digit runs as numbers (img2 < img10)
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		ca, cb := ra[i], rb[j]
+		if unicode.IsDigit(ca) && unicode.IsDigit(cb) {
+			si, sj := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			// 数值相同时，前导零少的排在前面
+			if i-si != j-sj {
+				return i-si < j-sj
+			}
+			continue
+		}
+
+		la, lb := unicode.ToLower(ca), unicode.ToLower(cb)
+		if la != lb {
+			return la < lb
+		}
+		i++
+		j++
+	}
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	// 忽略大小写后相同，再按原始字节比较保证顺序确定
+	return a < b
+}
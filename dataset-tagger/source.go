@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// datasetSource is where dataset files are read from: the local filesystem or a
+// read-only archive. Paths are absolute OS paths; archive entries appear below
+// the archive path as if it were a directory (set.zip/images/a.png).
+type datasetSource interface {
+	// ReadDir lists a directory sorted by name; symlinks are not resolved
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	// RealPath resolves symlinks, used to detect directory loops
+	RealPath(path string) (string, error)
+	Open(path string) (io.ReadCloser, error)
+	ReadFile(path string) ([]byte, error)
+	Close() error
+}
+
+// osSource reads from the local filesystem
+type osSource struct{}
+
+func (osSource) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// 读取目录后文件被删除，忽略即可
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (osSource) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (osSource) RealPath(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+func (osSource) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (osSource) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (osSource) Close() error {
+	return nil
+}
+
+// currentSource returns the source of the loaded dataset
+func (a *App) currentSource() datasetSource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.source
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// tagRulesFile holds the aliases and implications of one dataset, next to
+// datasetConfigFile in the dataset root
+const tagRulesFile = ".dataset-tagger-tags.json"
+
+// Scopes of tag rules: the dataset's own file or the global file shared by all
+// datasets of this user. Dataset rules win when both define the same tag.
+const (
+	TagRulesDataset = "dataset"
+	TagRulesGlobal  = "global"
+)
+
+// Kinds of rules imported from CSV
+const (
+	TagRuleAliases      = "aliases"
+	TagRuleImplications = "implications"
+)
+
+// Kinds of tag rule issues
+const (
+	// TagIssueAlias: the caption uses an alias instead of the canonical tag
+	TagIssueAlias = "alias"
+	// TagIssueMissingImplied: a tag implies another tag the caption lacks
+	TagIssueMissingImplied = "missing_implied"
+)
+
+// maxAliasChain stops alias resolution on chains that loop back on themselves
+const maxAliasChain = 16
+
+// TagRules maps alias tags to their canonical tag and tags to the tags they imply
+type TagRules struct {
+	// Aliases maps an alias ("1girls") to the canonical tag ("1girl")
+	Aliases map[string]string `json:"aliases"`
+	// Implications maps a tag ("cat ears") to the tags it implies ("animal ears")
+	Implications map[string][]string `json:"implications"`
+}
+
+func (r *TagRules) normalize() {
+	aliases := make(map[string]string, len(r.Aliases))
+	for from, to := range r.Aliases {
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if from != "" && to != "" && from != to {
+			aliases[from] = to
+		}
+	}
+	implications := make(map[string][]string, len(r.Implications))
+	for tag, implied := range r.Implications {
+		tag = strings.TrimSpace(tag)
+		for _, t := range implied {
+			t = strings.TrimSpace(t)
+			if tag != "" && t != "" && t != tag && !containsString(implications[tag], t) {
+				implications[tag] = append(implications[tag], t)
+			}
+		}
+	}
+	r.Aliases, r.Implications = aliases, implications
+}
+
+func (r *TagRules) validate() error {
+	for from := range r.Aliases {
+		if _, ok := r.resolve(from); !ok {
+			return fmt.Errorf("alias loop at %q", from)
+		}
+	}
+	return nil
+}
+
+// resolve follows the alias chain of tag; ok is false when the chain loops
+func (r TagRules) resolve(tag string) (string, bool) {
+	for i := 0; i < maxAliasChain; i++ {
+		to, ok := r.Aliases[tag]
+		if !ok {
+			return tag, true
+		}
+		tag = to
+	}
+	return tag, false
+}
+
+// canonical returns the canonical form of a bare tag
+func (r TagRules) canonical(tag string) string {
+	tag, _ = r.resolve(tag)
+	return tag
+}
+
+// implied returns every tag implied by tag, directly or through other
+// implications, in canonical form
+func (r TagRules) implied(tag string) []string {
+	result := make([]string, 0)
+	seen := map[string]bool{r.canonical(tag): true}
+	queue := []string{r.canonical(tag)}
+	for len(queue) > 0 {
+		for _, t := range r.Implications[queue[0]] {
+			t = r.canonical(t)
+			if !seen[t] {
+				seen[t] = true
+				result = append(result, t)
+				queue = append(queue, t)
+			}
+		}
+		queue = queue[1:]
+	}
+	return result
+}
+
+// mergeTagRules combines the global rules with the dataset's; dataset entries win
+func mergeTagRules(global, dataset TagRules) TagRules {
+	merged := TagRules{
+		Aliases:      make(map[string]string, len(global.Aliases)+len(dataset.Aliases)),
+		Implications: make(map[string][]string, len(global.Implications)+len(dataset.Implications)),
+	}
+	for _, r := range []TagRules{global, dataset} {
+		for from, to := range r.Aliases {
+			merged.Aliases[from] = to
+		}
+		for tag, implied := range r.Implications {
+			merged.Implications[tag] = append([]string(nil), implied...)
+		}
+	}
+	return merged
+}
+
+// globalTagRulesPath returns the per-user rules file
+func globalTagRulesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dataset-tagger", "tags.json"), nil
+}
+
+// loadTagRules reads a rules file; a missing file yields empty rules
+func loadTagRules(src datasetSource, path string) (TagRules, error) {
+	rules := TagRules{}
+	data, err := src.ReadFile(path)
+	if os.IsNotExist(err) {
+		rules.normalize()
+		return rules, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &rules)
+	}
+	rules.normalize()
+	if err == nil {
+		err = rules.validate()
+	}
+	if err != nil {
+		return TagRules{Aliases: map[string]string{}, Implications: map[string][]string{}}, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return rules, nil
+}
+
+// loadGlobalTagRules reads the per-user rules file
+func loadGlobalTagRules() (TagRules, error) {
+	path, err := globalTagRulesPath()
+	if err != nil {
+		return TagRules{Aliases: map[string]string{}, Implications: map[string][]string{}}, err
+	}
+	return loadTagRules(osSource{}, path)
+}
+
+// applyTagRules rewrites aliases to their canonical tag, keeping the emphasis,
+// and appends missing implied tags. An alias whose canonical tag is already in
+// the caption is removed instead.
+func applyTagRules(raw string, caption CaptionConfig, rules TagRules) string {
+	present := make(map[string]bool)
+	for _, tag := range captionTags(raw, caption) {
+		present[tag] = true
+	}
+	raw = editCaptionTags(raw, caption, func(text string) (string, bool) {
+		p := parsePromptTag(text)
+		canonical := rules.canonical(p.Tag)
+		if canonical == p.Tag {
+			return text, true
+		}
+		// 规范标签已存在（或已由前面的别名改写出来）时删除别名
+		if present[canonical] {
+			return text, false
+		}
+		present[canonical] = true
+		return p.render(canonical, WeightKeep, false), true
+	})
+
+	for _, missing := range missingImpliedTags(captionTags(raw, caption), rules) {
+		raw = addCaptionTag(raw, caption, missing, "append")
+	}
+	return raw
+}
+
+// missingImpliedTags returns the tags implied by tags that are not among them
+func missingImpliedTags(tags []string, rules TagRules) []string {
+	present := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		present[rules.canonical(tag)] = true
+	}
+	missing := make([]string, 0)
+	for _, tag := range tags {
+		for _, implied := range rules.implied(tag) {
+			if !present[implied] {
+				present[implied] = true
+				missing = append(missing, implied)
+			}
+		}
+	}
+	return missing
+}
+
+// TagRuleIssue flags one caption that uses an alias or lacks an implied tag
+type TagRuleIssue struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Tag is the alias used, or the tag implying the missing one
+	Tag string `json:"tag"`
+	// Suggestion is the canonical tag, or the missing implied tag
+	Suggestion string `json:"suggestion"`
+}
+
+// tagRules returns the merged rules of the loaded dataset; the caller must hold a.mu
+func (a *App) tagRules() TagRules {
+	return mergeTagRules(a.globalRules, a.datasetRules)
+}
+
+// GetTagRuleIssues lists the aliases and missing implied tags in the captions of itemIDs
+func (a *App) GetTagRuleIssues(itemIDs []string) []TagRuleIssue {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rules := a.tagRules()
+	issues := make([]TagRuleIssue, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		for _, tag := range item.Tags {
+			if canonical := rules.canonical(tag); canonical != tag {
+				issues = append(issues, TagRuleIssue{ID: item.ID, Path: item.MediaPath, Kind: TagIssueAlias, Tag: tag, Suggestion: canonical})
+			}
+		}
+		present := make(map[string]bool, len(item.Tags))
+		for _, tag := range item.Tags {
+			present[rules.canonical(tag)] = true
+		}
+		for _, tag := range item.Tags {
+			for _, implied := range rules.implied(tag) {
+				if !present[implied] {
+					present[implied] = true
+					issues = append(issues, TagRuleIssue{ID: item.ID, Path: item.MediaPath, Kind: TagIssueMissingImplied, Tag: tag, Suggestion: implied})
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// PreviewTagRules returns the captions of itemIDs that applying the aliases and
+// implications would change, without modifying anything
+func (a *App) PreviewTagRules(itemIDs []string) []CaptionChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rules := a.tagRules()
+	changes := make([]CaptionChange, 0)
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		item := a.items[idx]
+		after := applyTagRules(item.RawTags, a.config.Caption, rules)
+		if after != item.RawTags {
+			changes = append(changes, CaptionChange{ID: item.ID, Path: item.MediaPath, Before: item.RawTags, After: after})
+		}
+	}
+	return changes
+}
+
+// BatchApplyTagRules replaces aliases and adds missing implied tags in itemIDs;
+// like the other batch operations the captions are written by SaveAllChanges
+func (a *App) BatchApplyTagRules(itemIDs []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rules := a.tagRules()
+	for _, id := range itemIDs {
+		idx := a.itemIndexByID(id)
+		if idx == -1 {
+			continue
+		}
+		newTags := applyTagRules(a.items[idx].RawTags, a.config.Caption, rules)
+		if newTags == a.items[idx].RawTags {
+			continue
+		}
+		a.setItemCaption(idx, newTags)
+		a.items[idx].Modified = true
+	}
+	return nil
+}
+
+// GetTagRules returns the rules of one scope: "dataset" or "global"
+func (a *App) GetTagRules(scope string) (TagRules, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch scope {
+	case TagRulesDataset:
+		return a.datasetRules, nil
+	case TagRulesGlobal:
+		return a.globalRules, nil
+	}
+	return TagRules{}, fmt.Errorf("unknown tag rules scope: %s", scope)
+}
+
+// SaveTagRules validates and writes the rules of one scope
+func (a *App) SaveTagRules(scope string, rules TagRules) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.saveTagRules(scope, rules)
+}
+
+// saveTagRules writes the rules file of scope; the caller must hold a.mu
+func (a *App) saveTagRules(scope string, rules TagRules) error {
+	rules.normalize()
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	switch scope {
+	case TagRulesDataset:
+		if a.datasetPath == "" {
+			return fmt.Errorf("no dataset folder loaded")
+		}
+		if _, err := a.prepareWrite(false); err != nil {
+			return err
+		}
+		if err := a.writeDatasetFile(filepath.Join(a.datasetPath, tagRulesFile), data); err != nil {
+			return err
+		}
+		a.datasetRules = rules
+		return a.flushArchive()
+	case TagRulesGlobal:
+		path, err := globalTagRulesPath()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		a.globalRules = rules
+		return nil
+	}
+	return fmt.Errorf("unknown tag rules scope: %s", scope)
+}
+
+// SelectCSVFile opens a file dialog for a local CSV file
+func (a *App) SelectCSVFile() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "选择 CSV 文件",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "CSV (*.csv)", Pattern: "*.csv"},
+		},
+	})
+}
+
+// ImportTagRulesCSV merges aliases or implications from a Danbooru-style CSV
+// export into the rules of scope and returns the number of rules read. Rows are
+// "antecedent,consequent"; with a header, the antecedent_name/consequent_name
+// columns are used and rows whose status is not "active" are skipped. spaces
+// converts Danbooru underscores to spaces for datasets captioned that way.
+func (a *App) ImportTagRulesCSV(path string, kind string, scope string, spaces bool) (int, error) {
+	pairs, err := readRulePairsCSV(path)
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var rules TagRules
+	switch scope {
+	case TagRulesDataset:
+		rules = a.datasetRules
+	case TagRulesGlobal:
+		rules = a.globalRules
+	default:
+		return 0, fmt.Errorf("unknown tag rules scope: %s", scope)
+	}
+	merged := mergeTagRules(TagRules{}, rules)
+
+	for _, pair := range pairs {
+		from, to := pair[0], pair[1]
+		if spaces {
+			from, to = convertUnderscores(from, UnderscoresToSpace), convertUnderscores(to, UnderscoresToSpace)
+		}
+		switch kind {
+		case TagRuleAliases:
+			merged.Aliases[from] = to
+		case TagRuleImplications:
+			merged.Implications[from] = append(merged.Implications[from], to)
+		default:
+			return 0, fmt.Errorf("unknown tag rule kind: %s", kind)
+		}
+	}
+	if err := a.saveTagRules(scope, merged); err != nil {
+		return 0, err
+	}
+	return len(pairs), nil
+}
+
+// readRulePairsCSV reads the antecedent/consequent pairs of an alias or
+// implication CSV
+func readRulePairsCSV(path string) ([][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	from, to, status := 0, 1, -1
+	pairs := make([][2]string, 0)
+	for line := 0; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 0 {
+			if header := csvHeader(row); header["antecedent_name"] || header["antecedent"] {
+				from, to, status = csvColumn(row, "antecedent_name", "antecedent"), csvColumn(row, "consequent_name", "consequent"), csvColumn(row, "status")
+				continue
+			}
+		}
+		if from >= len(row) || to >= len(row) || to < 0 {
+			continue
+		}
+		if status >= 0 && status < len(row) && row[status] != "active" {
+			continue
+		}
+		if a, b := strings.TrimSpace(row[from]), strings.TrimSpace(row[to]); a != "" && b != "" {
+			pairs = append(pairs, [2]string{a, b})
+		}
+	}
+	return pairs, nil
+}
+
+func csvHeader(row []string) map[string]bool {
+	header := make(map[string]bool, len(row))
+	for _, name := range row {
+		header[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return header
+}
+
+// csvColumn returns the index of the first column named one of names, or -1
+func csvColumn(row []string, names ...string) int {
+	for i, name := range row {
+		if containsString(names, strings.ToLower(strings.TrimSpace(name))) {
+			return i
+		}
+	}
+	return -1
+}
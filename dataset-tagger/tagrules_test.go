@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// isolateUserConfig points the global rules file at an empty temporary directory
+func isolateUserConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv("HOME", dir)
+}
+
+func TestTagRulesResolve(t *testing.T) {
+	rules := TagRules{
+		Aliases: map[string]string{" 1girls ": "girl", "girl": "1girl", "same": "same", "": "x"},
+		Implications: map[string][]string{
+			"cat ears":    {"animal_ears", "animal_ears", "cat ears"},
+			"animal ears": {"ears"},
+		},
+	}
+	rules.Aliases["animal_ears"] = "animal ears"
+	rules.normalize()
+	if err := rules.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := rules.Aliases["same"]; ok {
+		t.Error("alias to itself kept")
+	}
+	if got := rules.canonical("1girls"); got != "1girl" {
+		t.Errorf("canonical(1girls) = %q, want 1girl through the chain", got)
+	}
+	if got := rules.Implications["cat ears"]; !reflect.DeepEqual(got, []string{"animal_ears"}) {
+		t.Errorf("normalized implications = %q", got)
+	}
+	// 蕴含是传递的，结果为规范形式
+	if got := rules.implied("cat ears"); !reflect.DeepEqual(got, []string{"animal ears", "ears"}) {
+		t.Errorf("implied(cat ears) = %q", got)
+	}
+
+	loop := TagRules{Aliases: map[string]string{"a": "b", "b": "a"}}
+	loop.normalize()
+	if err := loop.validate(); err == nil {
+		t.Error("alias loop accepted")
+	}
+}
+
+func TestMergeTagRulesDatasetWins(t *testing.T) {
+	global := TagRules{
+		Aliases:      map[string]string{"1girls": "1girl", "blonde": "blonde hair"},
+		Implications: map[string][]string{"cat ears": {"animal ears"}},
+	}
+	dataset := TagRules{
+		Aliases:      map[string]string{"blonde": "yellow hair"},
+		Implications: map[string][]string{"cat ears": {"cat"}},
+	}
+	merged := mergeTagRules(global, dataset)
+	if merged.Aliases["1girls"] != "1girl" || merged.Aliases["blonde"] != "yellow hair" {
+		t.Errorf("aliases = %v", merged.Aliases)
+	}
+	if got := merged.Implications["cat ears"]; !reflect.DeepEqual(got, []string{"cat"}) {
+		t.Errorf("implications = %q", got)
+	}
+	merged.Implications["cat ears"][0] = "changed"
+	if dataset.Implications["cat ears"][0] != "cat" {
+		t.Error("merged rules share slices with their source")
+	}
+}
+
+func TestApplyTagRules(t *testing.T) {
+	rules := TagRules{
+		Aliases:      map[string]string{"1girls": "1girl", "animal_ears": "animal ears"},
+		Implications: map[string][]string{"cat ears": {"animal ears"}},
+	}
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"1girls, smile", "1girl, smile"},
+		{"(1girls:1.2), smile", "(1girl:1.2), smile"},
+		{"1girl, 1girls, smile", "1girl, smile"},
+		{"1girls, 1girls", "1girl"},
+		{"cat ears, smile", "cat ears, smile, animal ears"},
+		{"cat ears, animal_ears", "cat ears, animal ears"},
+		{"1girl, smile", "1girl, smile"},
+	}
+	for _, c := range cases {
+		if got := applyTagRules(c.raw, tagsMode, rules); got != c.want {
+			t.Errorf("applyTagRules(%q) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestImportTagRulesCSVAndApply(t *testing.T) {
+	isolateUserConfig(t)
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "1girls, cat_ears",
+		"b.png": "",
+		"b.txt": "1girl, smile",
+		"aliases.csv": "id,antecedent_name,consequent_name,created_at,status\n" +
+			"1,1girls,1girl,2020-01-01,active\n" +
+			"2,smile,grin,2020-01-01,deleted\n",
+		"implications.csv": "cat_ears,animal_ears\n",
+	})
+	app := scanTestDataset(t, root)
+
+	n, err := app.ImportTagRulesCSV(filepath.Join(root, "aliases.csv"), TagRuleAliases, TagRulesDataset, false)
+	if err != nil || n != 1 {
+		t.Fatalf("import aliases = %d, %v; want 1 active row", n, err)
+	}
+	n, err = app.ImportTagRulesCSV(filepath.Join(root, "implications.csv"), TagRuleImplications, TagRulesDataset, false)
+	if err != nil || n != 1 {
+		t.Fatalf("import implications = %d, %v", n, err)
+	}
+	if _, err := app.ImportTagRulesCSV(filepath.Join(root, "aliases.csv"), "tags", TagRulesDataset, false); err == nil {
+		t.Fatal("unknown rule kind accepted")
+	}
+
+	// 导入的规则写入数据集文件，重新扫描后仍然生效
+	if _, err := os.Stat(filepath.Join(root, tagRulesFile)); err != nil {
+		t.Fatal(err)
+	}
+	app = scanTestDataset(t, root)
+	rules, err := app.GetTagRules(TagRulesDataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TagRules{
+		Aliases:      map[string]string{"1girls": "1girl"},
+		Implications: map[string][]string{"cat_ears": {"animal_ears"}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("saved rules = %+v, want %+v", rules, want)
+	}
+
+	a, b := testItem(t, app, "a.png").ID, testItem(t, app, "b.png").ID
+	issues := app.GetTagRuleIssues([]string{a, b})
+	wantIssues := []TagRuleIssue{
+		{ID: a, Path: filepath.Join(root, "a.png"), Kind: TagIssueAlias, Tag: "1girls", Suggestion: "1girl"},
+		{ID: a, Path: filepath.Join(root, "a.png"), Kind: TagIssueMissingImplied, Tag: "cat_ears", Suggestion: "animal_ears"},
+	}
+	if !reflect.DeepEqual(issues, wantIssues) {
+		t.Fatalf("issues = %+v, want %+v", issues, wantIssues)
+	}
+
+	// 规范化统计把别名合并到规范标签
+	stats, err := app.tagStats(TagStatsOptions{Normalized: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stats {
+		if s.Tag == "1girls" {
+			t.Fatal("alias counted separately")
+		}
+		if s.Tag == "1girl" && s.Docs != 2 {
+			t.Fatalf("1girl docs = %d, want 2", s.Docs)
+		}
+	}
+
+	changes := app.PreviewTagRules([]string{a, b})
+	if len(changes) != 1 || changes[0].After != "1girl, cat_ears, animal_ears" {
+		t.Fatalf("preview = %+v", changes)
+	}
+	if err := app.BatchApplyTagRules([]string{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	if item := testItem(t, app, "a.png"); item.RawTags != changes[0].After || !item.Modified {
+		t.Fatalf("a after apply = %q modified %v", item.RawTags, item.Modified)
+	}
+	if item := testItem(t, app, "b.png"); item.Modified {
+		t.Fatal("unchanged caption marked modified")
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Tag stats sort orders
+const (
+	TagSortCount    = "count"
+	TagSortDocs     = "docs"
+	TagSortTag      = "tag"
+	TagSortCategory = "category"
+	TagSortFirst    = "first"
+	TagSortLast     = "last"
+)
+
+var tagSorts = []string{TagSortCount, TagSortDocs, TagSortTag, TagSortCategory, TagSortFirst, TagSortLast}
+
+// TagStatsOptions selects what GetTagStats counts and returns
+type TagStatsOptions struct {
+	// Normalized counts tags through the normalization pipeline and aliases
+	Normalized bool `json:"normalized"`
+	// Category keeps only tags of this dictionary category (empty keeps all)
+	Category string `json:"category"`
+	// Sort is count or docs (most frequent first), tag, category, or first or
+	// last (by the natural order of that item's path); default count
+	Sort string `json:"sort"`
+	// Reverse flips the order
+	Reverse bool `json:"reverse"`
+}
+
+// TagStat is one exact tag of the dataset
+type TagStat struct {
+	Tag string `json:"tag"`
+	// Count is the number of occurrences, Docs the number of captions with the
+	// tag and Share that number over all items
+	Count int     `json:"count"`
+	Docs  int     `json:"docs"`
+	Share float64 `json:"share"`
+	// Category comes from the tag dictionary, empty when none is loaded
+	Category string `json:"category,omitempty"`
+	// FirstID and LastID are the first and last items with the tag, by media
+	// path in natural order (img2 before img10)
+	FirstID   string `json:"firstId"`
+	FirstPath string `json:"firstPath"`
+	LastID    string `json:"lastId"`
+	LastPath  string `json:"lastPath"`
+}
+
+// tagStats counts the exact tags of the loaded captions; the caller must hold a.mu
+func (a *App) tagStats(opts TagStatsOptions) ([]TagStat, error) {
+	switch {
+	case opts.Sort == "":
+		opts.Sort = TagSortCount
+	case !containsString(tagSorts, opts.Sort):
+		return nil, fmt.Errorf("unknown tag sort: %s", opts.Sort)
+	}
+	if opts.Category != "" && opts.Category != CategoryUnknown && !containsString(tagCategories, opts.Category) {
+		return nil, fmt.Errorf("unknown tag category: %s", opts.Category)
+	}
+
+	rules := a.tagRules()
+	key := func(tag string) string { return tag }
+	if opts.Normalized {
+		key = func(tag string) string {
+			// 别名先归到规范标签，规范化后再查一次别名
+			return rules.canonical(normalizeBareTag(rules.canonical(tag), a.config.Normalize))
+		}
+	}
+
+	byTag := make(map[string]*TagStat)
+	for _, item := range a.items {
+		seen := make(map[string]bool, len(item.Tags))
+		for _, tag := range item.Tags {
+			k := key(tag)
+			s := byTag[k]
+			if s == nil {
+				s = &TagStat{Tag: k, FirstID: item.ID, FirstPath: item.MediaPath, LastID: item.ID, LastPath: item.MediaPath}
+				byTag[k] = s
+			}
+			s.Count++
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			s.Docs++
+			// 与条目列表的默认排序一致，img2 排在 img10 前
+			if naturalLess(item.MediaPath, s.FirstPath) {
+				s.FirstID, s.FirstPath = item.ID, item.MediaPath
+			}
+			if naturalLess(s.LastPath, item.MediaPath) {
+				s.LastID, s.LastPath = item.ID, item.MediaPath
+			}
+		}
+	}
+
+	stats := make([]TagStat, 0, len(byTag))
+	for _, s := range byTag {
+		s.Share = float64(s.Docs) / float64(len(a.items))
+		s.Category = a.tagCategory(s.Tag, rules)
+		if opts.Category == "" || s.Category == opts.Category {
+			stats = append(stats, *s)
+		}
+	}
+	sortTagStats(stats, opts)
+	return stats, nil
+}
+
+// sortTagStats orders stats by opts.Sort; ties fall back to the tag
+func sortTagStats(stats []TagStat, opts TagStatsOptions) {
+	// 分类按统计时的顺序排列，未知分类排最后
+	rank := func(category string) int {
+		if i := indexOfString(tagCategories, category); i != -1 {
+			return i
+		}
+		return len(tagCategories)
+	}
+	less := func(a, b TagStat) bool {
+		switch opts.Sort {
+		case TagSortCount:
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+		case TagSortDocs:
+			if a.Docs != b.Docs {
+				return a.Docs > b.Docs
+			}
+		case TagSortCategory:
+			if ra, rb := rank(a.Category), rank(b.Category); ra != rb {
+				return ra < rb
+			}
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+		case TagSortFirst:
+			if a.FirstPath != b.FirstPath {
+				return naturalLess(a.FirstPath, b.FirstPath)
+			}
+		case TagSortLast:
+			if a.LastPath != b.LastPath {
+				return naturalLess(a.LastPath, b.LastPath)
+			}
+		}
+		return a.Tag < b.Tag
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if opts.Reverse {
+			return less(stats[j], stats[i])
+		}
+		return less(stats[i], stats[j])
+	})
+}
+
+// GetTagStats returns the exact comma-tag counts of bare tags (emphasis such as
+// "(tag:1.2)" removed) with their document share, dictionary category and the
+// first and last items they occur in. They are counted from the captions in
+// memory, so unsaved edits and batch operations are included. With
+// opts.Normalized, aliases are merged into their canonical tag and tags that
+// normalize to the same form are counted together under that form.
+func (a *App) GetTagStats(opts TagStatsOptions) ([]TagStat, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.tagStats(opts)
+}
+
+// writeTagStatsCSV writes stats with a header row; item paths are relative to root
+func writeTagStatsCSV(w io.Writer, stats []TagStat, root string) error {
+	rel := func(path string) string {
+		if r, err := filepath.Rel(root, path); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return path
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"tag", "count", "docs", "share", "category", "first", "last"})
+	for _, s := range stats {
+		cw.Write([]string{
+			s.Tag,
+			strconv.Itoa(s.Count),
+			strconv.Itoa(s.Docs),
+			strconv.FormatFloat(s.Share, 'f', 4, 64),
+			s.Category,
+			rel(s.FirstPath),
+			rel(s.LastPath),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportTagStatsCSV asks for a file and writes the tag stats of opts to it as
+// CSV; it returns the path written, or "" when the dialog was cancelled
+func (a *App) ExportTagStatsCSV(opts TagStatsOptions) (string, error) {
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "导出标签统计",
+		DefaultFilename: "tag-stats.csv",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "CSV (*.csv)", Pattern: "*.csv"},
+		},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats, err := a.tagStats(opts)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := writeTagStatsCSV(f, stats, a.datasetPath); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTagStatsFirstLastNaturalOrder(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"img2.png":  "",
+		"img2.txt":  "smile",
+		"img10.png": "",
+		"img10.txt": "smile, solo",
+		"img9.png":  "",
+		"img9.txt":  "solo",
+	})
+	app := scanTestDataset(t, root)
+
+	stats, err := app.tagStats(TagStatsOptions{Sort: TagSortFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ tag, first, last string }{
+		{"smile", "img2.png", "img10.png"},
+		{"solo", "img9.png", "img10.png"},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d stats, want %d", len(stats), len(want))
+	}
+	for i, w := range want {
+		s := stats[i]
+		if s.Tag != w.tag || s.FirstID != testItem(t, app, w.first).ID || s.LastID != testItem(t, app, w.last).ID {
+			t.Errorf("stats[%d] = %s first %s last %s, want %s first %s last %s",
+				i, s.Tag, s.FirstPath, s.LastPath, w.tag, w.first, w.last)
+		}
+	}
+
+	stats, err = app.tagStats(TagStatsOptions{Sort: TagSortLast, Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个标签最后都出现在 img10，按标签名反序
+	if stats[0].Tag != "solo" {
+		t.Errorf("last reversed starts with %s, want solo", stats[0].Tag)
+	}
+}
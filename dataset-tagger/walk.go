@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName holds gitignore-style patterns; one may be placed in any directory
+const ignoreFileName = ".taggerignore"
+
+// WalkConfig controls which files ScanFolder and the watcher visit
+type WalkConfig struct {
+	// MaxDepth limits recursion: 1 = only files in the root, 0 = unlimited
+	MaxDepth int `json:"maxDepth"`
+	// FollowSymlinks descends into symlinked directories; each real directory is visited once
+	FollowSymlinks bool `json:"followSymlinks"`
+	// IncludeHidden also visits dot-files and dot-directories such as .git
+	IncludeHidden bool `json:"includeHidden"`
+	// Ignore holds extra gitignore-style patterns applied from the dataset root
+	Ignore []string `json:"ignore"`
+}
+
+func defaultWalkConfig() WalkConfig {
+	return WalkConfig{Ignore: []string{}}
+}
+
+func (c *WalkConfig) normalize() {
+	if c.MaxDepth < 0 {
+		c.MaxDepth = 0
+	}
+	if c.Ignore == nil {
+		c.Ignore = []string{}
+	}
+}
+
+// ignoreRule is one compiled .taggerignore line
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the rules of one ignore file, relative to its directory
+type ignoreRules struct {
+	base  string
+	rules []ignoreRule
+}
+
+// parseIgnorePatterns compiles gitignore lines; invalid patterns are skipped
+func parseIgnorePatterns(base string, lines []string) *ignoreRules {
+	set := &ignoreRules{base: base}
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasSuffix(line, `\ `) {
+			line = strings.TrimSuffix(line, `\ `) + " "
+		} else {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// 模式中间含有 "/" 时相对于忽略文件所在目录匹配，否则匹配任意层级的文件名
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		expr := globToRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "(^|/)" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		set.rules = append(set.rules, rule)
+	}
+	return set
+}
+
+// globToRegexp translates a gitignore glob to a regular expression body
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// loadIgnoreFile reads dir/.taggerignore; a missing file yields nil
+func loadIgnoreFile(src datasetSource, dir string) (*ignoreRules, error) {
+	f, err := src.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return parseIgnorePatterns(dir, lines), scanner.Err()
+}
+
+// isIgnored checks path against every rule set; later rules win, as in git
+func isIgnored(stack []*ignoreRules, path string, isDir bool) bool {
+	ignored := false
+	for _, set := range stack {
+		rel, err := filepath.Rel(set.base, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, rule := range set.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// datasetWalker visits the files of a dataset root honouring WalkConfig and
+// .taggerignore files
+type datasetWalker struct {
+	src     datasetSource
+	root    string
+	cfg     WalkConfig
+	visited map[string]bool
+	// visit is called for every regular file; returning an error stops the walk
+	visit func(path string, info os.FileInfo) error
+	// onError receives paths that could not be read; the walk continues
+	onError func(path string, err error)
+}
+
+// walkDataset walks root and calls visit for each file that is not excluded
+func walkDataset(src datasetSource, root string, cfg WalkConfig, visit func(path string, info os.FileInfo) error, onError func(path string, err error)) error {
+	if onError == nil {
+		onError = func(string, error) {}
+	}
+	info, err := src.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+
+	w := &datasetWalker{src: src, root: root, cfg: cfg, visited: make(map[string]bool), visit: visit, onError: onError}
+	stack := []*ignoreRules{parseIgnorePatterns(root, cfg.Ignore)}
+	return w.walkDir(root, 1, stack)
+}
+
+func (w *datasetWalker) walkDir(dir string, depth int, stack []*ignoreRules) error {
+	// 记录真实路径，防止符号链接形成循环或重复访问同一目录
+	if real, err := w.src.RealPath(dir); err == nil {
+		if w.visited[real] {
+			return nil
+		}
+		w.visited[real] = true
+	}
+
+	rules, err := loadIgnoreFile(w.src, dir)
+	if err != nil {
+		w.onError(filepath.Join(dir, ignoreFileName), err)
+	}
+	if rules != nil {
+		stack = append(stack[:len(stack):len(stack)], rules)
+	}
+
+	entries, err := w.src.ReadDir(dir)
+	if err != nil {
+		if dir == w.root {
+			return err
+		}
+		w.onError(dir, err)
+		return nil
+	}
+
+	for _, info := range entries {
+		name := info.Name()
+		if !w.cfg.IncludeHidden && isHiddenName(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !w.cfg.FollowSymlinks {
+				continue
+			}
+			info, err = w.src.Stat(path)
+			if err != nil {
+				w.onError(path, err)
+				continue
+			}
+		}
+
+		if isIgnored(stack, path, info.IsDir()) {
+			continue
+		}
+
+		if info.IsDir() {
+			if w.cfg.MaxDepth > 0 && depth >= w.cfg.MaxDepth {
+				continue
+			}
+			if err := w.walkDir(path, depth+1, stack); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := w.visit(path, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// walkPaths returns the files walkDataset visits under root, relative and sorted
+func walkPaths(t *testing.T, root string, cfg WalkConfig) ([]string, []string) {
+	t.Helper()
+	paths := make([]string, 0)
+	failed := make([]string, 0)
+	rel := func(path string) string {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(r)
+	}
+	err := walkDataset(osSource{}, root, cfg, func(path string, info os.FileInfo) error {
+		paths = append(paths, rel(path))
+		return nil
+	}, func(path string, err error) {
+		failed = append(failed, rel(path))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths, failed
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{[]string{"*.bak"}, "a.bak", false, true},
+		{[]string{"*.bak"}, "deep/dir/a.bak", false, true},
+		{[]string{"*.bak"}, "a.txt", false, false},
+		{[]string{"_rejected/"}, "sub/_rejected", true, true},
+		{[]string{"_rejected/"}, "_rejected", false, false},
+		{[]string{"/top.txt"}, "top.txt", false, true},
+		{[]string{"/top.txt"}, "sub/top.txt", false, false},
+		{[]string{"sub/*.txt"}, "sub/a.txt", false, true},
+		{[]string{"sub/*.txt"}, "other/sub/a.txt", false, false},
+		{[]string{"**/cache"}, "a/b/cache", true, true},
+		{[]string{"a/**/b.txt"}, "a/x/y/b.txt", false, true},
+		{[]string{"a/**/b.txt"}, "a/b.txt", false, true},
+		{[]string{"img?.png"}, "img1.png", false, true},
+		{[]string{"img?.png"}, "img10.png", false, false},
+		{[]string{"[!a]*.txt"}, "b.txt", false, true},
+		{[]string{"[!a]*.txt"}, "a.txt", false, false},
+		{[]string{"*.txt", "!keep.txt"}, "keep.txt", false, false},
+		{[]string{"!keep.txt", "*.txt"}, "keep.txt", false, true},
+		{[]string{`\#hash`}, "#hash", false, true},
+		{[]string{"# comment", ""}, "# comment", false, false},
+	}
+	root := filepath.FromSlash("/data")
+	for _, c := range cases {
+		stack := []*ignoreRules{parseIgnorePatterns(root, c.patterns)}
+		path := filepath.Join(root, filepath.FromSlash(c.path))
+		if got := isIgnored(stack, path, c.isDir); got != c.want {
+			t.Errorf("%q ignoring %s = %v, want %v", c.patterns, c.path, got, c.want)
+		}
+	}
+}
+
+func TestWalkHonoursIgnoreFiles(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		ignoreFileName:              "_rejected/\n*.bak\n!keep.bak\n",
+		"a.txt":                     "",
+		"a.bak":                     "",
+		"keep.bak":                  "",
+		"_rejected/b.txt":           "",
+		"__pycache__/c.pyc":         "",
+		".git/config":               "",
+		".hidden.txt":               "",
+		"sub/" + ignoreFileName:     "/local.txt\n",
+		"sub/local.txt":             "",
+		"sub/d.txt":                 "",
+		"sub/deeper/local.txt":      "",
+		"sub/deeper/more/e.txt":     "",
+		"other/local.txt":           "",
+		"other/_rejected/inner.txt": "",
+	})
+	cfg := defaultWalkConfig()
+	cfg.Ignore = []string{"__pycache__/"}
+
+	got, failed := walkPaths(t, root, cfg)
+	want := []string{"a.txt", "keep.bak", "other/local.txt", "sub/d.txt", "sub/deeper/local.txt", "sub/deeper/more/e.txt"}
+	if !reflect.DeepEqual(got, want) || len(failed) != 0 {
+		t.Fatalf("walk = %q (errors %q), want %q", got, failed, want)
+	}
+
+	cfg.IncludeHidden = true
+	got, _ = walkPaths(t, root, cfg)
+	want = []string{".git/config", ".hidden.txt", ignoreFileName, "a.txt", "keep.bak", "other/local.txt",
+		"sub/" + ignoreFileName, "sub/d.txt", "sub/deeper/local.txt", "sub/deeper/more/e.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("walk with hidden files = %q, want %q", got, want)
+	}
+
+	cfg.IncludeHidden = false
+	cfg.MaxDepth = 2
+	got, _ = walkPaths(t, root, cfg)
+	want = []string{"a.txt", "keep.bak", "other/local.txt", "sub/d.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("walk with max depth 2 = %q, want %q", got, want)
+	}
+}
+
+func TestWalkFollowsSymlinksOnce(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.txt":     "",
+		"sub/b.txt": "",
+	})
+	outside := writeTestDataset(t, map[string]string{
+		"c.txt": "",
+	})
+	links := map[string]string{
+		"link":       filepath.Join(root, "sub"),
+		"sub/loop":   root,
+		"shared":     outside,
+		"sub/broken": filepath.Join(root, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks not available: %v", err)
+		}
+	}
+
+	cfg := defaultWalkConfig()
+	got, failed := walkPaths(t, root, cfg)
+	if want := []string{"a.txt", "sub/b.txt"}; !reflect.DeepEqual(got, want) || len(failed) != 0 {
+		t.Fatalf("walk without following = %q (errors %q), want %q", got, failed, want)
+	}
+
+	// link 与 sub 是同一个真实目录，只访问一次；sub/loop 指回根目录，不会形成循环
+	cfg.FollowSymlinks = true
+	got, failed = walkPaths(t, root, cfg)
+	if want := []string{"a.txt", "link/b.txt", "shared/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walk following symlinks = %q, want %q", got, want)
+	}
+	if want := []string{"link/broken"}; !reflect.DeepEqual(failed, want) {
+		t.Fatalf("walk errors = %q, want %q", failed, want)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Events pushed to the frontend while watching
+const (
+	EventDatasetChanged = "dataset:changed"
+)
+
+const defaultWatchInterval = 2 * time.Second
+
+// fileStamp is what the poller compares between two snapshots
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// datasetWatcher polls the dataset root and diffs snapshots. Polling works the
+// same on local disks and network shares, where inotify events are unreliable.
+type datasetWatcher struct {
+	root     string
+	pairing  *pairer
+	walk     WalkConfig
+	interval time.Duration
+	snapshot map[string]fileStamp
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// DatasetChange is the payload of the dataset:changed event
+type DatasetChange struct {
+	Added     []DatasetItem `json:"added"`
+	Removed   []string      `json:"removed"`
+	Updated   []DatasetItem `json:"updated"`
+	Conflicts []string      `json:"conflicts"`
+	Tags      []TagInfo     `json:"tags,omitempty"`
+	// Categories is set together with Tags
+	Categories []CategoryStat `json:"categories,omitempty"`
+}
+
+func (c DatasetChange) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Updated) == 0 && len(c.Conflicts) == 0
+}
+
+// StartWatching polls the current dataset folder for external changes.
+// intervalMs <= 0 uses the default interval.
+func (a *App) StartWatching(intervalMs int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.datasetPath == "" {
+		return fmt.Errorf("no dataset folder loaded")
+	}
+	if a.loadedArchive() != nil {
+		return fmt.Errorf("archives cannot be watched")
+	}
+	interval := defaultWatchInterval
+	if intervalMs > 0 {
+		interval = time.Duration(intervalMs) * time.Millisecond
+	}
+	a.startWatcher(interval)
+	return nil
+}
+
+// startWatcher replaces the running watcher, if any, with one polling every
+// interval under the current pairing and walk rules; the caller must hold a.mu
+func (a *App) startWatcher(interval time.Duration) {
+	a.stopWatcher()
+	w := &datasetWatcher{
+		root:     a.datasetPath,
+		interval: interval,
+		pairing:  a.pairing,
+		walk:     a.config.Walk,
+		snapshot: takeSnapshot(a.pairing, a.config.Walk, a.datasetPath),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	a.watcher = w
+	go a.watchLoop(w)
+}
+
+// StopWatching stops the folder watcher if it is running
+func (a *App) StopWatching() {
+	a.mu.Lock()
+	w := a.watcher
+	a.watcher = nil
+	a.mu.Unlock()
+
+	if w != nil {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// IsWatching reports whether the folder watcher is running
+func (a *App) IsWatching() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.watcher != nil
+}
+
+// stopWatcher signals the watcher without waiting; the caller must hold a.mu
+func (a *App) stopWatcher() {
+	if a.watcher != nil {
+		close(a.watcher.stop)
+		a.watcher = nil
+	}
+}
+
+func (a *App) watchLoop(w *datasetWatcher) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		snapshot := takeSnapshot(w.pairing, w.walk, w.root)
+		added, removed, modified := diffSnapshots(w.snapshot, snapshot)
+		w.snapshot = snapshot
+		if len(added)+len(removed)+len(modified) == 0 {
+			continue
+		}
+
+		a.mu.Lock()
+		// 扫描了其他文件夹，旧的监听结果作废
+		if a.watcher != w || a.datasetPath != w.root {
+			a.mu.Unlock()
+			continue
+		}
+		change := a.applyFileChanges(added, removed, modified, snapshot)
+		if !change.empty() {
+			change.Tags = a.analyzeCommonPhrases()
+			change.Categories = a.categoryStats()
+		}
+		a.mu.Unlock()
+
+		if !change.empty() {
+			a.emit(EventDatasetChanged, change)
+		}
+	}
+}
+
+// takeSnapshot records size and mtime of every media and caption file under root,
+// using the same walk rules as ScanFolder
+func takeSnapshot(p *pairer, walk WalkConfig, root string) map[string]fileStamp {
+	snapshot := make(map[string]fileStamp)
+	walkDataset(osSource{}, root, walk, func(path string, info os.FileInfo) error {
+		if role, _ := p.classify(path); role != fileOther {
+			snapshot[path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	}, nil)
+	return snapshot
+}
+
+// diffSnapshots returns the paths that appeared, disappeared or changed
+func diffSnapshots(prev, next map[string]fileStamp) (added, removed, modified []string) {
+	for path, stamp := range next {
+		old, ok := prev[path]
+		if !ok {
+			added = append(added, path)
+		} else if old != stamp {
+			modified = append(modified, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := next[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	return added, removed, modified
+}
+
+// applyFileChanges updates items and tag frequency for changed files.
+// Items with unsaved edits keep their in-app caption and are reported as conflicts.
+// The caller must hold a.mu.
+func (a *App) applyFileChanges(added, removed, modified []string, snapshot map[string]fileStamp) DatasetChange {
+	change := DatasetChange{Added: []DatasetItem{}, Removed: []string{}, Updated: []DatasetItem{}, Conflicts: []string{}}
+	captions := make([]string, 0)
+
+	for _, path := range removed {
+		if !a.isMediaPath(path) {
+			captions = append(captions, path)
+			continue
+		}
+		if i := a.itemIndexByMedia(path); i != -1 {
+			a.adjustTagFrequency(a.items[i].Tags, -1)
+			change.Removed = append(change.Removed, a.items[i].ID)
+			a.items = append(a.items[:i], a.items[i+1:]...)
+		}
+	}
+
+	for _, path := range added {
+		if !a.isMediaPath(path) {
+			captions = append(captions, path)
+			continue
+		}
+		key := mediaKey(path)
+		if a.itemIndexByID(key) != -1 {
+			continue
+		}
+		item := DatasetItem{
+			ID:        key,
+			MediaPath: path,
+			IsVideo:   a.pairing.isVideo(path),
+			Tags:      []string{},
+		}
+		applyKohyaInfo(a.datasetPath, &item)
+		applyMediaInfo(osSource{}, &item)
+		if txtPath := currentCaption(a.pairing, path, snapshot); txtPath != "" {
+			if content, err := os.ReadFile(txtPath); err == nil {
+				item.TxtPath = txtPath
+				item.RawTags = string(content)
+				item.Tags = a.parseTags(item.RawTags)
+				a.adjustTagFrequency(item.Tags, 1)
+			}
+		}
+		a.items = append(a.items, item)
+		change.Added = append(change.Added, item)
+	}
+
+	for _, path := range modified {
+		if !a.isMediaPath(path) {
+			captions = append(captions, path)
+			continue
+		}
+		// 媒体文件被替换，刷新尺寸等信息
+		if i := a.itemIndexByMedia(path); i != -1 {
+			applyMediaInfo(osSource{}, &a.items[i])
+			change.Updated = append(change.Updated, a.items[i])
+		}
+	}
+	for _, path := range captions {
+		role, key := a.pairing.classify(path)
+		if role != fileCaption {
+			continue
+		}
+		i := a.itemIndexByID(key)
+		if i == -1 {
+			continue
+		}
+		item := &a.items[i]
+
+		// 只关心当前生效的标注文件（优先级最高且存在的那个）
+		txtPath := currentCaption(a.pairing, item.MediaPath, snapshot)
+		if path != txtPath && path != item.TxtPath {
+			continue
+		}
+		content := ""
+		if txtPath != "" {
+			data, err := os.ReadFile(txtPath)
+			if err != nil {
+				continue
+			}
+			content = string(data)
+		}
+		if content == item.RawTags && txtPath == item.TxtPath {
+			// 本程序自己保存产生的变化
+			continue
+		}
+		if item.Modified {
+			change.Conflicts = append(change.Conflicts, item.ID)
+			continue
+		}
+
+		a.adjustTagFrequency(item.Tags, -1)
+		item.TxtPath = txtPath
+		item.RawTags = content
+		item.Tags = a.parseTags(content)
+		a.adjustTagFrequency(item.Tags, 1)
+		change.Updated = append(change.Updated, *item)
+	}
+
+	if len(change.Added) > 0 {
+		a.applySortOrder()
+	}
+	return change
+}
+
+// adjustTagFrequency adds delta to the exact-tag counts of tags
+func (a *App) adjustTagFrequency(tags []string, delta int) {
+	for _, tag := range tags {
+		a.tagFrequency[tag] += delta
+		if a.tagFrequency[tag] <= 0 {
+			delete(a.tagFrequency, tag)
+		}
+	}
+}
+
+func (a *App) itemIndexByID(id string) int {
+	for i, item := range a.items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// mediaKey returns the pairing key (directory + basename) used as item ID
+func mediaKey(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// repairCaptions picks the caption file of every item again after the pairing
+// rules changed; items with unsaved edits keep their caption text and write it
+// to the new file when saved. The caller must hold a.mu.
+func (a *App) repairCaptions() {
+	for i := range a.items {
+		item := &a.items[i]
+		txtPath := ""
+		for _, path := range a.pairing.captionCandidates(item.MediaPath) {
+			if _, err := a.source.Stat(path); err == nil {
+				txtPath = path
+				break
+			}
+		}
+		if txtPath == item.TxtPath {
+			continue
+		}
+		item.TxtPath = txtPath
+		if item.Modified {
+			continue
+		}
+		content := ""
+		if txtPath != "" {
+			data, err := a.source.ReadFile(txtPath)
+			if err != nil {
+				continue
+			}
+			content = string(data)
+		}
+		a.setItemCaption(i, content)
+	}
+}
+
+// currentCaption returns the highest-priority caption of a media file present in snapshot
+func currentCaption(p *pairer, mediaPath string, snapshot map[string]fileStamp) string {
+	for _, path := range p.captionCandidates(mediaPath) {
+		if _, ok := snapshot[path]; ok {
+			return path
+		}
+	}
+	return ""
+}
+
+func (a *App) isMediaPath(path string) bool {
+	role, _ := a.pairing.classify(path)
+	return role == fileImage || role == fileVideo
+}
+
+// emit sends an event to the frontend; it is a no-op in headless CLI mode
+func (a *App) emit(name string, data ...interface{}) {
+	if a.ctx == nil {
+		return
+	}
+	runtime.EventsEmit(a.ctx, name, data...)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExternalEditAfterSavedBatchEdit(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "1girl, smile",
+		"b.png": "",
+		"b.txt": "1boy",
+	})
+	app := scanTestDataset(t, root)
+	a := testItem(t, app, "a.png")
+
+	if err := app.BatchAddTag([]string{a.ID}, "solo", "append"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.SaveAllChanges(app.GetItems()); err != nil {
+		t.Fatal(err)
+	}
+	if item := testItem(t, app, "a.png"); item.Modified {
+		t.Fatal("item still marked modified after saving")
+	}
+
+	before := takeSnapshot(app.pairing, app.config.Walk, root)
+	if err := os.WriteFile(a.TxtPath, []byte("1girl, smile, solo, outdoors"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	after := takeSnapshot(app.pairing, app.config.Walk, root)
+	added, removed, modified := diffSnapshots(before, after)
+	if len(modified) == 0 {
+		// 修改时间精度不足时，直接把标注文件作为已修改
+		modified = []string{a.TxtPath}
+	}
+
+	app.mu.Lock()
+	change := app.applyFileChanges(added, removed, modified, after)
+	app.mu.Unlock()
+
+	if len(change.Conflicts) != 0 {
+		t.Fatalf("external edit reported as conflict: %v", change.Conflicts)
+	}
+	if len(change.Updated) != 1 || change.Updated[0].ID != a.ID {
+		t.Fatalf("updated = %+v, want item %s", change.Updated, a.ID)
+	}
+	if got := testItem(t, app, "a.png").RawTags; got != "1girl, smile, solo, outdoors" {
+		t.Fatalf("caption = %q, want the external edit", got)
+	}
+}
+
+func TestExternalEditConflictsWithUnsavedEdit(t *testing.T) {
+	root := writeTestDataset(t, map[string]string{
+		"a.png": "",
+		"a.txt": "1girl",
+	})
+	app := scanTestDataset(t, root)
+	a := testItem(t, app, "a.png")
+
+	if err := app.BatchAddTag([]string{a.ID}, "solo", "append"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := takeSnapshot(app.pairing, app.config.Walk, root)
+	if err := os.WriteFile(a.TxtPath, []byte("1girl, outdoors"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app.mu.Lock()
+	change := app.applyFileChanges(nil, nil, []string{a.TxtPath}, snapshot)
+	app.mu.Unlock()
+
+	if len(change.Conflicts) != 1 || change.Conflicts[0] != a.ID {
+		t.Fatalf("conflicts = %v, want %s", change.Conflicts, a.ID)
+	}
+	if got := testItem(t, app, "a.png").RawTags; got != "1girl, solo" {
+		t.Fatalf("caption = %q, want the unsaved edit kept", got)
+	}
+}
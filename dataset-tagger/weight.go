@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Weight modes: what rewriting a tag does with its SD prompt emphasis
+const (
+	// WeightKeep keeps the original syntax: "(blue eyes:1.2)" stays "(... :1.2)"
+	WeightKeep = "keep"
+	// WeightExplicit rewrites emphasis as an explicit weight: "((tag))" -> "(tag:1.21)"
+	WeightExplicit = "explicit"
+	// WeightStrip drops the emphasis and keeps the bare tag
+	WeightStrip = "strip"
+)
+
+// emphasisFactor is the weight multiplier of one "(" layer (and divisor of "[")
+const emphasisFactor = 1.1
+
+func validateWeightMode(mode string) error {
+	switch mode {
+	case WeightKeep, WeightExplicit, WeightStrip:
+		return nil
+	}
+	return fmt.Errorf("unknown weight mode: %s", mode)
+}
+
+// PromptTag is one tag of a caption with its SD prompt emphasis parsed out:
+// "((blue eyes))", "(blue eyes:1.2)" and "[blue eyes]" all have the tag "blue eyes"
+type PromptTag struct {
+	// Text is the tag as written in the caption
+	Text string `json:"text"`
+	// Tag is the bare tag used for matching, filtering and counting
+	Tag string `json:"tag"`
+	// Weight is the effective weight, 1 when the tag has no emphasis
+	Weight float64 `json:"weight"`
+	// Emphasis counts "(" layers as positive and "[" layers as negative
+	Emphasis int `json:"emphasis"`
+	// Explicit is set when the weight was written as "(tag:1.2)"
+	Explicit bool `json:"explicit"`
+
+	open, close string // the syntax around the tag text, e.g. "((" and ":1.2))"
+	escaped     bool   // the tag text escapes its parentheses: "fate \(series\)"
+}
+
+// parsePromptTag splits the emphasis syntax wrapping text from the bare tag.
+// Brackets that do not wrap the whole tag, as in "fate (series)", are part of it,
+// and so are brackets that would leave no tag, as in the emoticon "(:3)".
+func parsePromptTag(text string) PromptTag {
+	p := PromptTag{Text: text, Weight: 1}
+	inner, layers := text, 0
+	for len(inner) >= 2 && !p.Explicit {
+		var closing byte
+		switch inner[0] {
+		case '(':
+			closing = ')'
+		case '[':
+			closing = ']'
+		}
+		if closing == 0 || closingBracket(inner, inner[0], closing) != len(inner)-1 {
+			break
+		}
+
+		body := inner[1 : len(inner)-1]
+		if strings.TrimSpace(body) == "" {
+			// "()" 括号里没有标签，整体就是标签
+			break
+		}
+		if closing == ']' {
+			p.Emphasis--
+			p.Weight /= emphasisFactor
+		} else {
+			factor := emphasisFactor
+			// 显式权重只出现在最内层：((tag:1.2)) = 1.2 × 1.1
+			if i := strings.LastIndexByte(body, ':'); i != -1 {
+				if w, err := strconv.ParseFloat(strings.TrimSpace(body[i+1:]), 64); err == nil {
+					if strings.TrimSpace(body[:i]) == "" {
+						// 冒号前为空的 "(:3)" 是颜文字，不是权重
+						break
+					}
+					factor, body, p.Explicit = w, body[:i], true
+				}
+			}
+			p.Emphasis++
+			p.Weight *= factor
+		}
+		inner = body
+		layers++
+	}
+
+	// 每层只占开头一个字节，权重写在结尾
+	p.open, p.close = text[:layers], text[layers+len(inner):]
+	p.escaped = strings.Contains(inner, `\(`) || strings.Contains(inner, `\)`)
+	p.Tag = strings.TrimSpace(unescapePrompt(inner))
+	p.Weight = math.Round(p.Weight*1e4) / 1e4
+	return p
+}
+
+// closingBracket returns the index of the bracket closing s[0], skipping escaped ones
+func closingBracket(s string, open, closing byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func unescapePrompt(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\(`, "(", `\)`, ")", `\[`, "[", `\]`, "]").Replace(s)
+}
+
+// weighted reports whether the tag carries any emphasis
+func (p PromptTag) weighted() bool {
+	return p.Emphasis != 0
+}
+
+// render writes tag (a bare tag) with the emphasis of p according to mode.
+// Parentheses inside the tag are escaped when the original escaped them or when
+// they sit inside emphasis syntax, where they would otherwise change the weight.
+func (p PromptTag) render(tag string, mode string, escape bool) string {
+	wrapped := p.weighted() && mode != WeightStrip
+	if escape || p.escaped || (wrapped && strings.ContainsAny(tag, "()")) {
+		tag = escapeParens(tag)
+	}
+	switch {
+	case !p.weighted() || mode == WeightStrip:
+		return tag
+	case mode == WeightExplicit:
+		if p.Weight == 1 {
+			return tag
+		}
+		return "(" + tag + ":" + strconv.FormatFloat(p.Weight, 'f', -1, 64) + ")"
+	}
+	return p.open + tag + p.close
+}
+
+// bareTag returns the tag of text without emphasis syntax
+func bareTag(text string) string {
+	return parsePromptTag(text).Tag
+}
+
+// ParseCaptionTags returns the tags of a caption with their weights parsed, for
+// showing emphasis in the editor
+func (a *App) ParseCaptionTags(raw string) []PromptTag {
+	a.mu.Lock()
+	cfg := a.config.Caption
+	a.mu.Unlock()
+
+	tags := make([]PromptTag, 0)
+	for _, line := range splitCaptionLines(raw, cfg) {
+		if line.tags {
+			for _, text := range splitTagList(line.text) {
+				tags = append(tags, parsePromptTag(text))
+			}
+		}
+	}
+	return tags
+}
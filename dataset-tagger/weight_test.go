@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestParsePromptTag(t *testing.T) {
+	for _, tt := range []struct {
+		text     string
+		tag      string
+		weight   float64
+		emphasis int
+		explicit bool
+	}{
+		{"blue eyes", "blue eyes", 1, 0, false},
+		{"(blue eyes)", "blue eyes", 1.1, 1, false},
+		{"((blue eyes))", "blue eyes", 1.21, 2, false},
+		{"[blue eyes]", "blue eyes", 0.9091, -1, false},
+		{"(blue eyes:1.2)", "blue eyes", 1.2, 1, true},
+		{"(blue eyes: 0.5)", "blue eyes", 0.5, 1, true},
+		{"((blue eyes:1.2))", "blue eyes", 1.32, 2, true},
+		{"( blue eyes )", "blue eyes", 1.1, 1, false},
+		{"fate (series)", "fate (series)", 1, 0, false},
+		{`fate \(series\)`, "fate (series)", 1, 0, false},
+		{`(fate \(series\):1.1)`, "fate (series)", 1.1, 1, true},
+		{"(blue) (eyes)", "(blue) (eyes)", 1, 0, false},
+		{"re:zero", "re:zero", 1, 0, false},
+		{"(re:zero)", "re:zero", 1.1, 1, false},
+		// 颜文字和空括号原样作为标签
+		{":3", ":3", 1, 0, false},
+		{"(:3)", "(:3)", 1, 0, false},
+		{"( :3)", "( :3)", 1, 0, false},
+		{"((:3))", "(:3)", 1.1, 1, false},
+		{"()", "()", 1, 0, false},
+		{"[]", "[]", 1, 0, false},
+	} {
+		p := parsePromptTag(tt.text)
+		if p.Tag != tt.tag || p.Weight != tt.weight || p.Emphasis != tt.emphasis || p.Explicit != tt.explicit {
+			t.Errorf("parsePromptTag(%q) = %q weight %v emphasis %d explicit %v; want %q weight %v emphasis %d explicit %v",
+				tt.text, p.Tag, p.Weight, p.Emphasis, p.Explicit, tt.tag, tt.weight, tt.emphasis, tt.explicit)
+		}
+		if p.Text != tt.text {
+			t.Errorf("parsePromptTag(%q).Text = %q", tt.text, p.Text)
+		}
+	}
+}
+
+func TestPromptTagRenderKeepsEmoticon(t *testing.T) {
+	p := parsePromptTag("(:3)")
+	if got := p.render(p.Tag, WeightKeep, false); got != "(:3)" {
+		t.Errorf("render = %q, want the emoticon unchanged", got)
+	}
+}
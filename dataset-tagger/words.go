@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Phrase modes accepted by RefreshTagStats
+const (
+	// PhraseModeAuto picks chars or words per caption by its script
+	PhraseModeAuto = "auto"
+	// PhraseModeChars counts rune substrings, for scripts the segmenter does not know
+	PhraseModeChars = "chars"
+	// PhraseModeWords counts word n-grams: spaced scripts split at spaces, Chinese
+	// by the segmenter
+	PhraseModeWords = "words"
+)
+
+var phraseModes = []string{PhraseModeAuto, PhraseModeChars, PhraseModeWords}
+
+func validatePhraseMode(mode string) error {
+	if !containsString(phraseModes, mode) {
+		return fmt.Errorf("unknown phrase mode: %s", mode)
+	}
+	return nil
+}
+
+// phraseStopwords never start or end a word phrase
+var phraseStopwords = toSet(strings.Fields(`
+	a an the and or but nor so yet of in on at to for from by with without into onto
+	over under about above below between through during before after up down out off
+	is are was were be been being am has have had having do does did will would shall
+	should can could may might must this that these those there here it its it's he
+	she they them their his her him we us our you your i me my mine as than then
+	not no very too also just only some any each every all both either neither other
+	such which who whom whose what when where why how while if
+	的 地 得 了 着 过 在 是 和 与 及 或 也 都 就 而 被 把 对 从 向 这 那 其 之 等 有
+	一个 一些 一种 一位 一只 一张 一名 这个 那个 这些 那些 非常 比较 正在 已经
+`))
+
+func toSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// runeCaption reports whether auto mode counts text by runes: Japanese, whose
+// kana the segmenter has no dictionary for. Chinese captions are segmented and
+// other scripts split at spaces.
+func runeCaption(text string) bool {
+	kana, cjk, other := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+			cjk++
+		case unicode.Is(unicode.Han, r):
+			cjk++
+		case unicode.IsLetter(r):
+			other++
+		}
+	}
+	return kana > 0 && cjk >= other
+}
+
+// countsByRunes reports whether a caption is counted by runes in mode
+func countsByRunes(mode string, text string) bool {
+	switch mode {
+	case PhraseModeChars:
+		return true
+	case PhraseModeWords:
+		return false
+	}
+	return runeCaption(text)
+}
+
+// phraseWords splits text at punctuation into runs of lower-case words; word
+// phrases never cross punctuation. Underscores and hyphens separate words, and
+// words with Chinese characters are split further by seg when it is set.
+func phraseWords(text string, seg *segmenter) [][]string {
+	runs := make([][]string, 0)
+	words := make([]string, 0)
+	var word strings.Builder
+	flushWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := strings.ToLower(strings.Trim(word.String(), "'"))
+		word.Reset()
+		if seg != nil && strings.IndexFunc(w, isHan) != -1 {
+			words = append(words, seg.cut(w)...)
+		} else if w != "" {
+			words = append(words, w)
+		}
+	}
+	flushRun := func() {
+		flushWord()
+		if len(words) > 0 {
+			runs = append(runs, words)
+			words = make([]string, 0)
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || (r == '\'' && word.Len() > 0):
+			word.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '_' || r == '-':
+			flushWord()
+		default:
+			flushRun()
+		}
+	}
+	flushRun()
+	return runs
+}
+
+// wordIndex counts word n-grams per caption. Unlike runes, a caption has at most
+// maxLen n-grams per word, so they are counted directly.
+type wordIndex struct {
+	segmenter *segmenter
+	// maxLen is the longest phrase counted, in words
+	maxLen int
+	docs   map[string]string
+	counts map[string]int32
+}
+
+func newWordIndex(seg *segmenter, maxLen int) *wordIndex {
+	return &wordIndex{segmenter: seg, maxLen: maxLen, docs: make(map[string]string), counts: make(map[string]int32)}
+}
+
+// ngrams returns the distinct word n-grams of text, joined by spaces
+func (x *wordIndex) ngrams(text string) map[string]bool {
+	grams := make(map[string]bool)
+	for _, run := range phraseWords(text, x.segmenter) {
+		for i := range run {
+			for n := 1; n <= x.maxLen && i+n <= len(run); n++ {
+				grams[strings.Join(run[i:i+n], " ")] = true
+			}
+		}
+	}
+	return grams
+}
+
+func (x *wordIndex) count(text string, delta int32) {
+	for gram := range x.ngrams(text) {
+		if x.counts[gram] += delta; x.counts[gram] == 0 {
+			delete(x.counts, gram)
+		}
+	}
+}
+
+// sync brings the index in line with the captions of items
+func (x *wordIndex) sync(items []DatasetItem) {
+	current := make(map[string]bool, len(items))
+	for _, item := range items {
+		current[item.ID] = true
+	}
+	for id, text := range x.docs {
+		if !current[id] {
+			x.count(text, -1)
+			delete(x.docs, id)
+		}
+	}
+	for _, item := range items {
+		text, ok := x.docs[item.ID]
+		if ok && text == item.RawTags {
+			continue
+		}
+		if ok {
+			x.count(text, -1)
+		}
+		x.count(item.RawTags, 1)
+		x.docs[item.ID] = item.RawTags
+	}
+}
+
+// stopwordBounded reports whether a phrase starts or ends with a stopword; single
+// characters are not phrases either
+func stopwordBounded(words []string, filter phraseFilter) bool {
+	if len(words) == 1 && utf8.RuneCountInString(words[0]) == 1 {
+		return true
+	}
+	return filter.isStopword(words[0]) || filter.isStopword(words[len(words)-1])
+}
+
+// joinPhraseWords spells a word phrase out: Chinese words are written together,
+// other words with spaces between them
+func joinPhraseWords(words []string) string {
+	var b strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			last, _ := utf8.DecodeLastRuneInString(words[i-1])
+			first, _ := utf8.DecodeRuneInString(w)
+			if !isHan(last) && !isHan(first) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+// common returns the phrases of cfg.MinWords to maxLen words in at least
+// cfg.MinDocs captions, most frequent first, then longest first. Phrases that
+// are bounded by stopwords, blocked or too widespread are skipped, and a phrase
+// is dropped when a longer listed phrase containing it occurs in the same
+// captions.
+func (x *wordIndex) common(cfg PhraseConfig, maxDocs int32, filter phraseFilter) []TagInfo {
+	listed := make(map[string]int32)
+	for gram, n := range x.counts {
+		if n < int32(cfg.MinDocs) || n > maxDocs {
+			continue
+		}
+		words := strings.Split(gram, " ")
+		if len(words) < cfg.MinWords || stopwordBounded(words, filter) || filter.blocked[joinPhraseWords(words)] {
+			continue
+		}
+		listed[gram] = n
+	}
+	dropped := make(map[string]bool)
+	for gram, n := range listed {
+		words := strings.Split(gram, " ")
+		for i := range words {
+			for j := i + 1; j <= len(words); j++ {
+				if j-i == len(words) {
+					continue
+				}
+				if sub := strings.Join(words[i:j], " "); listed[sub] == n {
+					dropped[sub] = true
+				}
+			}
+		}
+	}
+
+	// 不同切分可能写出同一个短语，保留出现次数多的
+	spelled := make(map[string]int32)
+	for gram, n := range listed {
+		if text := joinPhraseWords(strings.Split(gram, " ")); !dropped[gram] && n > spelled[text] {
+			spelled[text] = n
+		}
+	}
+	phrases := make([]TagInfo, 0, len(spelled))
+	for text, n := range spelled {
+		phrases = append(phrases, TagInfo{Tag: text, Count: int(n)})
+	}
+	sortPhrases(phrases)
+	if len(phrases) > cfg.Limit {
+		phrases = phrases[:cfg.Limit]
+	}
+	return phrases
+}
+
+// sortPhrases orders phrases by caption count, then length, then text
+func sortPhrases(phrases []TagInfo) {
+	sort.Slice(phrases, func(i, j int) bool {
+		if phrases[i].Count != phrases[j].Count {
+			return phrases[i].Count > phrases[j].Count
+		}
+		li, lj := utf8.RuneCountInString(phrases[i].Tag), utf8.RuneCountInString(phrases[j].Tag)
+		if li != lj {
+			return li > lj
+		}
+		return phrases[i].Tag < phrases[j].Tag
+	})
+}